@@ -0,0 +1,358 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to backup
+	gokiBackupDir   string = "/cockroach/backup" // Directory in each node that the backup volume is mounted to (--external-io-dir).
+	gokiBackupLabel string = "goki-backup"       // Label of the backup volume. It is different from gokiResourceLabel, so "goki delete -v" keeps backups.
+	gokiIntroDb     string = "intro"             // Database that "goki create" loads.
+)
+
+// Flag value of backup command (common in subcommands).
+var backupCmdFlags struct {
+	db string // Name of the database. If it is empty, the target is the whole cluster.
+}
+
+// Flag value of backup create command.
+var backupCreateCmdFlags struct {
+	incremental     bool   // Whether take an incremental backup on the latest full backup or not.
+	revisionHistory bool   // Whether take a backup with revision history (for point-in-time restore) or not.
+	schedule        string // Crontab of the backup schedule.
+}
+
+// Flag value of backup restore command.
+var backupRestoreCmdFlags struct {
+	from      string // Path of the backup in the collection (e.g. /2024/05/01-120000.00). If it is empty, the latest backup.
+	asOf      string // Timestamp to restore (point-in-time restore).
+	newDbName string // New name of the restored database.
+}
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backup and restore the CockroachDB Local Cluster",
+	Long: `The "goki backup" command takes backups of the cluster or a database by BACKUP statement, and restores them by RESTORE statement.
+Backups are stored in the Docker Volume ` + gokiResourceName + `-backup via nodelocal://1 storage. So, the node 1 must be running.
+The "goki delete -v" command does not delete the backups, so that you can restore them into a freshly created cluster.
+If you don't need the backups, delete the volume by "docker volume rm ` + gokiResourceName + `-backup".`,
+}
+
+// backupCreateCmd represents the backup create command
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Take a backup",
+	Long: `The "goki backup create" command takes a full backup of the cluster.
+    goki backup create
+* You can take a backup of a database with --db flag.
+    goki backup create --db intro
+* You can take an incremental backup on the latest full backup with --incremental flag.
+    goki backup create --incremental
+* You can take a backup with revision history for point-in-time restore with --revision-history flag.
+    goki backup create --revision-history
+* You can create a backup schedule instead of taking a backup now with --schedule flag (crontab).
+    goki backup create --schedule '@hourly' --revision-history
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiBackupNode(); err != nil {
+			return err
+		}
+
+		stmt, err := backupStatement(backupCmdFlags.db, backupCreateCmdFlags.incremental, backupCreateCmdFlags.revisionHistory, backupCreateCmdFlags.schedule)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. %v.\n", err)
+			return err
+		}
+
+		fmt.Println("INFO: " + stmt)
+		output, err := execGokiSql(gokiResourceName+"-1", "table", stmt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Taking backup failed.\n Error is: %v\n", string(output))
+			if backupCreateCmdFlags.incremental {
+				fmt.Fprintln(os.Stderr, "HINT: An incremental backup needs a full backup. Please take it by \"goki backup create\" without --incremental flag.")
+			}
+			return err
+		}
+		fmt.Println(string(output))
+
+		return nil
+	},
+}
+
+// backupListCmd represents the backup list command
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backups and backup schedules",
+	Long: `The "goki backup list" command shows backups of the cluster and all databases, and backup schedules created by Goki.
+* You can show only backups of a database with --db flag.
+    goki backup list --db intro
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiBackupNode(); err != nil {
+			return err
+		}
+
+		if err := showGokiBackups(backupCmdFlags.db); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// backupRestoreCmd represents the backup restore command
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a backup",
+	Long: `The "goki backup restore" command restores the latest backup of the cluster.
+A full cluster restore needs the cluster that has no user data. If the cluster has only "intro" database loaded by "goki create", it is dropped before restoring.
+    goki backup restore
+* You can restore a database with --db flag. You can also restore it with another name with --new-db-name flag.
+    goki backup restore --db intro --new-db-name intro_restored
+* You can specify the backup (path shown by "goki backup list") with --from flag.
+    goki backup restore --from /2024/05/01-120000.00
+* You can restore the data at the specified time (needs a backup with --revision-history) with --as-of flag.
+    goki backup restore --as-of '2024-05-01 12:34:56'
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiBackupNode(); err != nil {
+			return err
+		}
+
+		if backupRestoreCmdFlags.newDbName != "" && backupCmdFlags.db == "" {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --new-db-name flag needs --db flag.")
+			return errors.New("invalid argument. The --new-db-name flag needs --db flag")
+		}
+
+		// A full cluster restore fails if the cluster has user data.
+		if backupCmdFlags.db == "" {
+			if err := prepareClusterRestore(); err != nil {
+				return err
+			}
+		}
+
+		stmt := restoreStatement(backupCmdFlags.db, backupRestoreCmdFlags.from, backupRestoreCmdFlags.asOf, backupRestoreCmdFlags.newDbName)
+		fmt.Println("INFO: " + stmt)
+		output, err := execGokiSql(gokiResourceName+"-1", "table", stmt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Restoring backup failed.\n Error is: %v\n", string(output))
+			return err
+		}
+		fmt.Println(string(output))
+
+		if backupCmdFlags.db == "" {
+			fmt.Println("INFO: The users and passwords are also restored from the backup.")
+		}
+
+		return nil
+	},
+}
+
+// checkGokiBackupNode() checks the node 1 that has nodelocal://1 storage is running.
+func checkGokiBackupNode() error {
+	containers, err := getGokiContainers()
+	if err != nil {
+		return err
+	}
+	if c, ok := containers[gokiResourceName+"-1"]; !ok || c.state != "running" {
+		fmt.Fprintln(os.Stderr, "ERROR: The node 1 is not running. Goki stores backups via nodelocal://1 storage.")
+		fmt.Fprintln(os.Stderr, "HINT: Please create the cluster by \"goki create\" command, or revive the node 1 by \"goki revive -g 1\" command.")
+		return errors.New("the node 1 is not running")
+	}
+	return nil
+}
+
+// gokiBackupUri() returns the URI of the backup collection of the cluster or the database.
+func gokiBackupUri(db string) string {
+	if db == "" {
+		return "nodelocal://1/cluster"
+	}
+	return "nodelocal://1/database/" + url.PathEscape(db)
+}
+
+func backupStatement(db string, incremental bool, revisionHistory bool, schedule string) (string, error) {
+	target := ""
+	label := gokiBackupLabel + "-cluster"
+	if db != "" {
+		target = "DATABASE " + pq.QuoteIdentifier(db) + " "
+		label = gokiBackupLabel + "-" + db
+	}
+	opts := ""
+	if revisionHistory {
+		opts = " WITH revision_history"
+	}
+
+	if schedule != "" {
+		if incremental {
+			return "", errors.New("the --incremental flag cannot be used with --schedule flag. The schedule takes incremental backups automatically")
+		}
+		return "CREATE SCHEDULE IF NOT EXISTS " + pq.QuoteLiteral(label) + " FOR BACKUP " + target +
+			"INTO " + pq.QuoteLiteral(gokiBackupUri(db)) + opts +
+			" RECURRING " + pq.QuoteLiteral(schedule) + " WITH SCHEDULE OPTIONS first_run = 'now'", nil
+	}
+
+	if incremental {
+		return "BACKUP " + target + "INTO LATEST IN " + pq.QuoteLiteral(gokiBackupUri(db)) + opts, nil
+	}
+	return "BACKUP " + target + "INTO " + pq.QuoteLiteral(gokiBackupUri(db)) + opts, nil
+}
+
+func restoreStatement(db string, from string, asOf string, newDbName string) string {
+	stmt := "RESTORE "
+	if db != "" {
+		stmt += "DATABASE " + pq.QuoteIdentifier(db) + " "
+	}
+	if from == "" {
+		stmt += "FROM LATEST IN " + pq.QuoteLiteral(gokiBackupUri(db))
+	} else {
+		stmt += "FROM " + pq.QuoteLiteral(from) + " IN " + pq.QuoteLiteral(gokiBackupUri(db))
+	}
+	if asOf != "" {
+		stmt += " AS OF SYSTEM TIME " + pq.QuoteLiteral(asOf)
+	}
+	if newDbName != "" {
+		stmt += " WITH new_db_name = " + pq.QuoteLiteral(newDbName)
+	}
+	return stmt
+}
+
+// prepareClusterRestore() drops "intro" database if it is the only user database, since a full cluster restore needs the cluster that has no user data.
+func prepareClusterRestore() error {
+	output, err := execGokiSql(gokiResourceName+"-1", "csv",
+		"SELECT database_name FROM [SHOW DATABASES] WHERE database_name NOT IN ('defaultdb', 'postgres', 'system')")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting databases failed.\n Error is: %v\n", string(output))
+		return err
+	}
+
+	lines := strings.Fields(string(output))
+	dbs := []string{}
+	if len(lines) > 1 {
+		dbs = lines[1:]
+	}
+	if len(dbs) == 0 {
+		return nil
+	} else if len(dbs) == 1 && dbs[0] == gokiIntroDb {
+		fmt.Println("INFO: Drop \"" + gokiIntroDb + "\" database loaded by \"goki create\" before restoring the cluster.")
+		if output, err := execGokiSql(gokiResourceName+"-1", "table", "DROP DATABASE "+gokiIntroDb+" CASCADE"); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Dropping \"%v\" database failed.\n Error is: %v\n", gokiIntroDb, string(output))
+			return err
+		}
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "ERROR: A full cluster restore needs the cluster that has no user data. The cluster has the following databases: %v\n", strings.Join(dbs, ", "))
+	fmt.Fprintln(os.Stderr, "HINT: Please restore a database with --db flag, or re-create the cluster by \"goki delete -v\" and \"goki create\" command.")
+	return errors.New("the cluster has user data")
+}
+
+// gokiBackupCollections() returns the backup collections ("cluster" and "database/<name>") in the backup volume.
+func gokiBackupCollections() ([]string, error) {
+	c := gokiCommand("docker", "exec", gokiResourceName+"-1",
+		"find", gokiBackupDir, "-mindepth", "1", "-maxdepth", "2", "-type", "d")
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Listing backups failed.\n Error is: %v\n", string(output))
+		fmt.Fprintln(os.Stderr, "HINT: The cluster created by old Goki does not have the backup volume. Please re-create the cluster by \"goki delete\" and \"goki create\" command.")
+		return nil, err
+	}
+
+	// A database name can contain spaces. So, split the output by lines.
+	collections := []string{}
+	for _, dir := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		rel := strings.TrimPrefix(dir, gokiBackupDir+"/")
+		if rel == "cluster" || strings.HasPrefix(rel, "database/") {
+			collections = append(collections, rel)
+		}
+	}
+	sort.Strings(collections)
+	return collections, nil
+}
+
+func showGokiBackups(db string) error {
+	collections, err := gokiBackupCollections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		name := strings.TrimPrefix(collection, "database/")
+		if collection == "cluster" {
+			name = ""
+		} else if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+		if db != "" && name != db {
+			continue
+		}
+
+		if name == "" {
+			fmt.Printf("Backups of the cluster (%v):\n", gokiBackupUri(name))
+		} else {
+			fmt.Printf("Backups of the database %v (%v):\n", name, gokiBackupUri(name))
+		}
+		output, err := execGokiSql(gokiResourceName+"-1", "table", "SHOW BACKUPS IN "+pq.QuoteLiteral(gokiBackupUri(name)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: SHOW BACKUPS failed.\n Error is: %v\n", string(output))
+			return err
+		}
+		fmt.Println(string(output))
+	}
+	if len(collections) == 0 {
+		fmt.Println("INFO: There is no backup.")
+	}
+
+	fmt.Println("Backup schedules:")
+	output, err := execGokiSql(gokiResourceName+"-1", "table",
+		"SELECT id, label, schedule_status, recurrence, next_run FROM [SHOW SCHEDULES] WHERE label LIKE "+pq.QuoteLiteral(gokiBackupLabel+"-%"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: SHOW SCHEDULES failed.\n Error is: %v\n", string(output))
+		return err
+	}
+	fmt.Println(string(output))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	// Flags of goki backup (common in subcommands).
+	backupCmd.PersistentFlags().StringVar(&backupCmdFlags.db, "db", "", "Name of the database. (default: the whole cluster)")
+	// Flags of goki backup create.
+	backupCreateCmd.Flags().BoolVar(&backupCreateCmdFlags.incremental, "incremental", false, "Take an incremental backup on the latest full backup.")
+	backupCreateCmd.Flags().BoolVar(&backupCreateCmdFlags.revisionHistory, "revision-history", false, "Take a backup with revision history for point-in-time restore.")
+	backupCreateCmd.Flags().StringVar(&backupCreateCmdFlags.schedule, "schedule", "", "Create a backup schedule with the crontab (e.g. '@hourly') instead of taking a backup now.")
+	// Flags of goki backup restore.
+	backupRestoreCmd.Flags().StringVar(&backupRestoreCmdFlags.from, "from", "", "Path of the backup shown by \"goki backup list\". (default: the latest backup)")
+	backupRestoreCmd.Flags().StringVar(&backupRestoreCmdFlags.asOf, "as-of", "", "Timestamp to restore (needs a backup with --revision-history).")
+	backupRestoreCmd.Flags().StringVar(&backupRestoreCmdFlags.newDbName, "new-db-name", "", "New name of the restored database (needs --db).")
+}
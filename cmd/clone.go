@@ -0,0 +1,525 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	gokiCloneDir string = "clones" // Directory of clone metadata in the Goki config directory.
+)
+
+// Flag value of clone command.
+var cloneCmdFlags struct {
+	from      string // Resource prefix of the source cluster.
+	to        string // Resource prefix of the new cluster.
+	sqlPort   int    // Published port of the first node of the new cluster for SQL connection.
+	webUiPort int    // Published port of the first node of the new cluster for HTTP request (Web UI).
+}
+
+// Metadata of a cloned cluster that Goki stores in the user's config directory.
+// Each node of the clone keeps the host name of the source node (e.g. goki-1), since the certs and the cluster data refer to it.
+type gokiClone struct {
+	Name       string                  `json:"name"`                 // Resource prefix of the clone.
+	From       string                  `json:"from"`                 // Resource prefix of the source cluster.
+	Image      string                  `json:"image"`                // Container image of CockroachDB.
+	Binary     string                  `json:"binary,omitempty"`     // Path of the locally built cockroach binary, if it is mounted.
+	Stores     int                     `json:"stores,omitempty"`     // Number of stores per node.
+	Encryption *gokiEncryptionMetadata `json:"encryption,omitempty"` // Store keys of encryption at rest. The keys are in the cloned certs volume.
+	Nodes      []gokiNodeMetadata      `json:"nodes"`                // Each node. Name is the host name in the network of the clone.
+	Credential gokiCredential          `json:"credential"`           // Credentials of users in the cloned data.
+	SqlAddr    string                  `json:"sql_addr"`             // Published address for SQL connection.
+	WebUiAddr  string                  `json:"web_ui_addr"`          // Published address for HTTP request (Web UI).
+	CreatedAt  time.Time               `json:"created_at"`           // Time when "goki clone" created the clone.
+}
+
+// cloneCmd represents the clone command
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Clone the cluster into a second named cluster",
+	Long: `The "goki clone" command duplicates the volumes (DB data and certs) of the cluster under a new resource prefix,
+and starts the new cluster on its own Docker Network with its own ports. It is useful for A/B experiments.
+The source cluster is stopped while copying the volumes, so that the clone is consistent.
+Other goki commands do not manage the clone. Please access it by "docker exec" and delete it by "goki clone rm".
+* By default, it clones "goki" cluster, and the first node of the clone listens on 127.0.0.1:26258 and 127.0.0.1:8082.
+    goki clone --to experiment
+* You can specify the source (goki or another clone) and the ports with --from, --sql-port, and --http-port flag.
+    goki clone --from experiment --to experiment2 --sql-port 26259 --http-port 8083
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiCloneName(cloneCmdFlags.to); err != nil {
+			return err
+		}
+
+		c, err := newGokiClone(cloneCmdFlags.from, cloneCmdFlags.to)
+		if err != nil {
+			return err
+		}
+
+		if err := createGokiClone(c); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// cloneLsCmd represents the clone ls command
+var cloneLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List clones",
+	Long:  `The "goki clone ls" command shows all clones created by "goki clone".`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		clones, err := listGokiClones()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tFROM\tNODES\tSQL\tWEB UI\tCREATED")
+		for _, c := range clones {
+			fmt.Fprintf(w, "%v\t%v\t%d\t%v\t%v\t%v\n", c.Name, c.From, len(c.Nodes), c.SqlAddr, c.WebUiAddr, c.CreatedAt.Local().Format(time.RFC3339))
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+// cloneRmCmd represents the clone rm command
+var cloneRmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Delete clones",
+	Long:  `The "goki clone rm" command deletes the containers, network, and volumes of the clones.`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		for _, name := range args {
+			if err := deleteGokiClone(name); err != nil {
+				return err
+			}
+			fmt.Println("The clone " + name + " was deleted.")
+		}
+
+		return nil
+	},
+}
+
+func checkGokiCloneName(name string) error {
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the name of the clone with --to flag.")
+		return errors.New("invalid argument. The --to flag is required")
+	}
+	if name == gokiResourceName || !gokiSnapshotNameRegexp.MatchString(name) {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The name of the clone must not be \"%v\", and must consist of alphanumeric characters, \"_\", \".\", and \"-\".\n", gokiResourceName)
+		return errors.New("invalid argument. Invalid name of the clone")
+	}
+
+	// The clone must not conflict with existing resources.
+	for _, kind := range []string{"container", "network", "volume"} {
+		args := []string{kind, "ls", "-q", "-f", "label=" + name}
+		if kind == "container" {
+			args = append(args, "-a")
+		}
+		c := gokiCommand("docker", args...)
+		if output, err := c.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "docker %v ls command failed: %v\n", kind, string(output))
+			return err
+		} else if len(output) != 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: The resources of %v already exist.\n", name)
+			fmt.Fprintf(os.Stderr, "HINT: Please delete them by \"goki clone rm %v\" command, or use another name.\n", name)
+			return errors.New("the resources of the clone already exist")
+		}
+	}
+	return nil
+}
+
+// newGokiClone() creates the metadata of the clone from the source cluster.
+func newGokiClone(from string, to string) (*gokiClone, error) {
+	c := &gokiClone{
+		Name:      to,
+		From:      from,
+		SqlAddr:   gokiSqlIp + ":" + strconv.Itoa(cloneCmdFlags.sqlPort),
+		WebUiAddr: gokiWebUiIp + ":" + strconv.Itoa(cloneCmdFlags.webUiPort),
+		CreatedAt: time.Now(),
+	}
+
+	if from != gokiResourceName {
+		src, err := readGokiClone(from)
+		if err != nil {
+			return nil, err
+		}
+		c.Image = src.Image
+		c.Binary = src.Binary
+		c.Stores = src.Stores
+		c.Encryption = src.Encryption
+		c.Nodes = src.Nodes
+		c.Credential = src.Credential
+		return c, nil
+	}
+
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return nil, err
+	}
+	c.Image = gokiImage()
+	c.Credential = defaultGokiCredential()
+	if m != nil && m.MemStore != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: The Goki Cluster uses in-memory stores. There is no data to clone.")
+		return nil, errors.New("the Goki Cluster uses in-memory stores")
+	}
+	if m != nil {
+		c.Binary = m.Binary
+		c.Stores = m.Stores
+		c.Encryption = m.Encryption
+		c.Nodes = m.Nodes
+		c.Credential = m.Credential
+	} else {
+		// If there is no metadata (e.g. the cluster was created by old Goki), get the nodes from the volumes.
+		volumes, err := getGokiVolumes()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range gokiSnapshotNodeVolumes(volumes) {
+			if id, err := strconv.Atoi(strings.TrimPrefix(v, gokiResourceName+"-volume-")); err == nil {
+				c.Nodes = append(c.Nodes, gokiNodeMetadata{Id: id, Name: gokiResourceName + "-" + strconv.Itoa(id), Locality: gokiLocality(id, false)})
+			}
+		}
+	}
+	if len(c.Nodes) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: There is no volume of the Goki Cluster.")
+		fmt.Fprintln(os.Stderr, "HINT: Please create the cluster by \"goki create\" command.")
+		return nil, errors.New("there is no volume of the Goki Cluster")
+	}
+	return c, nil
+}
+
+func createGokiClone(c *gokiClone) error {
+	fmt.Printf("INFO: *** Start Cloning %v into %v ***\n", c.From, c.Name)
+
+	// Stop the source nodes while copying, so that the data of all nodes are consistent.
+	running, err := runningContainersOf(c.From, c.Nodes)
+	if err != nil {
+		return err
+	}
+	if len(running) != 0 {
+		fmt.Println("INFO: Stopping nodes: " + strings.Join(running, ", "))
+		stop := gokiCommand("docker", append([]string{"stop", "-t", strconv.Itoa(gokiSnapshotStopTimeout)}, running...)...)
+		if output, err := stop.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker stop command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+	}
+
+	copyErr := copyGokiCloneVolumes(c)
+
+	// Start the source nodes again, even if copying failed.
+	if len(running) != 0 {
+		fmt.Println("INFO: Starting nodes: " + strings.Join(running, ", "))
+		start := gokiCommand("docker", append([]string{"start"}, running...)...)
+		if output, err := start.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker start command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if err := writeGokiClone(c); err != nil {
+		return err
+	}
+
+	if err := startGokiClone(c); err != nil {
+		return err
+	}
+
+	fmt.Printf("*** Cloning %v into %v done ***\n", c.From, c.Name)
+	fmt.Printf("\nAccess DB of the clone as a root user:\n")
+	fmt.Printf("  docker exec -it %v-client ./cockroach sql --certs-dir=/cockroach/certs/ --host=%v:26257\n", c.Name, c.Nodes[0].Name)
+	fmt.Printf("\nAccess DB of the clone as a non-root user:\n")
+	fmt.Printf("  postgresql://%v:<password>@%v/defaultdb?sslmode=require\n", c.Credential.NonRootUserName, c.SqlAddr)
+	fmt.Printf("\nAccess Web UI of the clone:\n")
+	fmt.Printf("  URL: https://%v/\n\n", c.WebUiAddr)
+	return nil
+}
+
+// runningContainersOf() returns the running node containers of the cluster that has the prefix.
+func runningContainersOf(prefix string, nodes []gokiNodeMetadata) ([]string, error) {
+	c := gokiCommand("docker", "ps", "--format", "{{.Names}}")
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
+		return nil, err
+	}
+	live := strings.Fields(string(output))
+
+	running := []string{}
+	for _, n := range nodes {
+		if name := prefix + "-" + strconv.Itoa(n.Id); gokiContains(live, name) {
+			running = append(running, name)
+		}
+	}
+	return running, nil
+}
+
+func copyGokiCloneVolumes(c *gokiClone) error {
+	suffixes := []string{"-volume-client"}
+	for _, n := range c.Nodes {
+		for _, volume := range gokiStoreVolumesOf("", n.Id, c.Stores, "") {
+			suffixes = append(suffixes, volume)
+		}
+	}
+	for _, suffix := range suffixes {
+		if err := copyGokiVolume(c.From+suffix, c.Name+suffix, c.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startGokiClone() starts the network, client, and nodes of the clone in the same way as "goki create".
+func startGokiClone(c *gokiClone) error {
+	cmd := gokiCommand("docker", "network", "create", "-d", "bridge", c.Name+"-net", "--label="+c.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker network create command failed.\n Error is: %v\n", string(output))
+		return err
+	}
+
+	// The clone also mounts the locally built binary of the source cluster, if any.
+	binaryMounts := gokiBinaryMounts(c.Binary)
+
+	clientArgs := []string{"run", "-d",
+		"--name=" + c.Name + "-client",
+		"--hostname=" + c.Name + "-client",
+		"--network=" + c.Name + "-net",
+		"--mount=type=volume,src=" + c.Name + "-volume-client,dst=/cockroach/certs",
+	}
+	clientArgs = append(clientArgs, binaryMounts...)
+	clientArgs = append(clientArgs,
+		"--label="+c.Name,
+		"--entrypoint=sleep",
+		c.Image,
+		"inf",
+	)
+	cmd = gokiCommand("docker", clientArgs...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker run command that creating %v-client failed.\n Error is: %v\n", c.Name, string(output))
+		return err
+	}
+
+	join := []string{}
+	for i := 0; i < len(c.Nodes) && i < 3; i++ {
+		join = append(join, c.Nodes[i].Name)
+	}
+	for i, n := range c.Nodes {
+		args := []string{"run", "-d",
+			"--name=" + c.Name + "-" + strconv.Itoa(n.Id),
+			"--hostname=" + n.Name,
+			"--network=" + c.Name + "-net",
+			"--network-alias=" + n.Name,
+		}
+		// Only the first node publishes its ports.
+		if i == 0 {
+			args = append(args, "-p", c.SqlAddr+":26257", "-p", c.WebUiAddr+":8080")
+		}
+		args = append(args, "--mount=type=volume,src="+c.Name+"-volume-client,dst=/cockroach/certs")
+		for k, volume := range gokiStoreVolumesOf(c.Name, n.Id, c.Stores, "") {
+			args = append(args, "--mount=type=volume,src="+volume+",dst="+gokiStorePath(k+1))
+		}
+		args = append(args, binaryMounts...)
+		for _, env := range n.Env {
+			args = append(args, "--env="+env)
+		}
+		args = append(args, gokiResourceLimitArgs(n.Cpus, n.Memory)...)
+		// Each node of a mixed-version cluster keeps its own image.
+		image := c.Image
+		if n.Image != "" {
+			image = n.Image
+		}
+		args = append(args,
+			"--label="+c.Name,
+			image,
+			"start",
+			"--certs-dir=certs/node-certs/"+n.Name,
+			"--join="+strings.Join(join, ","),
+			"--locality="+n.Locality,
+		)
+		args = append(args, gokiStoreFlags(c.Stores, "", c.Encryption)...)
+		args = append(args, n.StartFlags...)
+		cmd := gokiCommand("docker", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Start node %v-%d failed.\n Error is: %v\n", c.Name, n.Id, string(output))
+			return err
+		}
+		fmt.Printf("INFO: Started container is: %v-%d\n", c.Name, n.Id)
+	}
+
+	return waitSqlConnectionAcceptance(c.Name+"-client", c.Nodes[0].Name)
+}
+
+func deleteGokiClone(name string) error {
+	if name == gokiResourceName || !gokiSnapshotNameRegexp.MatchString(name) {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. %v is not a clone.\n", name)
+		return errors.New("invalid argument. Invalid name of the clone")
+	}
+
+	c := gokiCommand("docker", "ps", "-aq", "-f", "label="+name)
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
+		return err
+	}
+	if ids := strings.Fields(string(output)); len(ids) != 0 {
+		if output, err := gokiCommand("docker", append([]string{"rm", "-f"}, ids...)...).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "docker rm command failed: %v\n", string(output))
+			return err
+		}
+	}
+
+	for _, kind := range []string{"network", "volume"} {
+		output, err := gokiCommand("docker", kind, "ls", "-q", "-f", "label="+name).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "docker %v ls command failed: %v\n", kind, string(output))
+			return err
+		}
+		if ids := strings.Fields(string(output)); len(ids) != 0 {
+			if output, err := gokiCommand("docker", append([]string{kind, "rm"}, ids...)...).CombinedOutput(); err != nil {
+				fmt.Fprintf(os.Stderr, "docker %v rm command failed: %v\n", kind, string(output))
+				return err
+			}
+		}
+	}
+
+	path, err := gokiClonePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "ERROR: Removing clone metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
+
+func gokiClonePath(name string) (string, error) {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), gokiCloneDir, name+".json"), nil
+}
+
+func readGokiClone(name string) (*gokiClone, error) {
+	path, err := gokiClonePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "ERROR: The clone %v does not exist.\n", name)
+		fmt.Fprintln(os.Stderr, "HINT: You can see the clones by \"goki clone ls\" command.")
+		return nil, err
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Reading clone metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+
+	var c gokiClone
+	if err := json.Unmarshal(b, &c); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Parsing clone metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+	return &c, nil
+}
+
+func writeGokiClone(c *gokiClone) error {
+	path, err := gokiClonePath(c.Name)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Encoding clone metadata failed.\n Error is: %v\n", err)
+		return err
+	}
+
+	// The clone metadata includes passwords. So, only the owner can read it.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating clone directory failed.\n Error is: %v\n", err)
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Writing clone metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
+
+func listGokiClones() ([]gokiClone, error) {
+	path, err := gokiClonePath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []gokiClone{}, nil
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Reading clone directory failed.\n Error is: %v\n", err)
+		return nil, err
+	}
+
+	clones := []gokiClone{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		c, err := readGokiClone(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, *c)
+	}
+	return clones, nil
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+	cloneCmd.AddCommand(cloneLsCmd)
+	cloneCmd.AddCommand(cloneRmCmd)
+	// Flags of goki clone.
+	cloneCmd.Flags().StringVar(&cloneCmdFlags.from, "from", gokiResourceName, "Resource prefix of the source cluster (goki or another clone).")
+	cloneCmd.Flags().StringVar(&cloneCmdFlags.to, "to", "", "Resource prefix of the new cluster.")
+	cloneCmd.Flags().IntVar(&cloneCmdFlags.sqlPort, "sql-port", 26258, "Published port of the first node of the clone for SQL connection.")
+	cloneCmd.Flags().IntVar(&cloneCmdFlags.webUiPort, "http-port", 8082, "Published port of the first node of the clone for HTTP request (Web UI).")
+}
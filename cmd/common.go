@@ -18,9 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -34,12 +35,20 @@ const (
 	gokiSqlPort             string = "26257"     // Port that the first contarner will listen for SQL connection.
 	gokiWebUiIp             string = "127.0.0.1" // IP that the first container will listen for HTTP request (Web UI).
 	gokiWebUiPort           string = "8081"      // Port that the first container will listen for HTTP request (Web UI).
-	gokiNonRootUserName     string = "goki"      // Name of Non-root user.
-	gokiNonRootUserPassword string = "goki"      // Password of Non-root user.
-	gokiRootUserPassword    string = "gokiroot"  // Password of Root user.
+	gokiNonRootUserName     string = "goki"      // Default name of Non-root user.
+	gokiNonRootUserPassword string = "goki"      // Default password of Non-root user.
+	gokiRootUserPassword    string = "gokiroot"  // Default password of Root user.
 	gokiResourceLabel       string = "goki"      // Label that will be specifed each docker resources.
+	// Timeouts
+	gokiStatusTimeout time.Duration = 15 * time.Second // Timeout of getting CockroachDB node status.
+	// Related to goki status --watch
+	gokiWatchMaxEvents int = 10 // Max number of events that watch mode shows.
 )
 
+// Label of temporary containers (e.g. the one that reads log files).
+// It is different from gokiResourceLabel, so that a left one does not block "goki create".
+const gokiHelperLabel string = "goki-helper"
+
 func gokiIsDead(id int) (bool, error) {
 	// List of running containers.
 	var liveGokiList []string = []string{}
@@ -47,7 +56,7 @@ func gokiIsDead(id int) (bool, error) {
 	var deadGokiList []string = []string{}
 
 	// Get list of running container that related to Goki.
-	c := exec.Command("docker", "ps", "-f", "label="+gokiResourceLabel, "--format", "{{.Names}}")
+	c := gokiCommand("docker", "ps", "-f", "label="+gokiResourceLabel, "--format", "{{.Names}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
@@ -63,7 +72,7 @@ func gokiIsDead(id int) (bool, error) {
 	}
 
 	// Get list of stopped containers that related to Goki.
-	c = exec.Command("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}")
+	c = gokiCommand("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
@@ -80,3 +89,160 @@ func gokiIsDead(id int) (bool, error) {
 		return false, errors.New("The specified container " + gokiResourceName + "-" + strconv.Itoa(id) + " does not exist")
 	}
 }
+
+// copyFilesToContainer() copies the files (key is the relative path from dst) to the container by "docker cp".
+// The container does not need to be running.
+func copyFilesToContainer(container string, dst string, files map[string][]byte) error {
+	dir, err := os.MkdirTemp("", gokiResourceName+"-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating temporary directory failed.\n Error is: %v\n", err)
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The files must be readable by the user in the container.
+	if err := os.Chmod(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Changing permission of temporary directory failed.\n Error is: %v\n", err)
+		return err
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Creating temporary directory failed.\n Error is: %v\n", err)
+			return err
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Writing temporary file failed.\n Error is: %v\n", err)
+			return err
+		}
+	}
+
+	// If dst does not exist, "docker cp" creates it.
+	c := gokiCommand("docker", "cp", dir+string(filepath.Separator)+".", container+":"+dst)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker cp command that copying files to %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+	return nil
+}
+
+// parseGokiSize() parses the size with the unit (e.g. 100KB, 1mb, 100MiB) into bytes.
+// KB, MB, and GB are powers of 1000, and KiB, MiB, and GiB are powers of 1024. The unit is case-insensitive.
+func parseGokiSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+		{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	v := strings.ToLower(strings.TrimSpace(s))
+	size := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			size = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size " + strconv.Quote(s))
+	}
+	return int64(n * float64(size)), nil
+}
+
+// execGokiSql() executes the statements as a root user via the client container, and returns the output in the format (e.g. table, csv).
+func execGokiSql(host string, format string, stmt string) ([]byte, error) {
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
+		"./cockroach", "sql",
+		"--format="+format,
+		"--certs-dir=/cockroach/certs/",
+		"--host="+host+":26257",
+		"-e", stmt,
+	)
+	return c.CombinedOutput()
+}
+
+func startContainer(container string) error {
+	c := gokiCommand("docker", "start", container)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker start command that starting %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+	fmt.Println("INFO: Started container is: " + container)
+	return nil
+}
+
+// gokiRunningNode() returns the name of the first running node of the cluster.
+func gokiRunningNode(m *gokiMetadata) (string, error) {
+	ids, err := gokiNodeIds(m)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		if dead, err := gokiIsDead(id); err == nil && !dead {
+			return gokiResourceName + "-" + strconv.Itoa(id), nil
+		}
+	}
+	fmt.Fprintln(os.Stderr, "ERROR: There is no running node of the Goki Cluster.")
+	fmt.Fprintln(os.Stderr, "HINT: Please start the nodes by \"goki revive\" command.")
+	return "", errors.New("there is no running node of the Goki Cluster")
+}
+
+// recreateGokiNode() stops and removes the node, and runs it again from the metadata (e.g. with new flags of "cockroach start").
+// The data in the volumes is kept. It waits until the node accepts SQL connections, and times out after gokiRecreateTimeout.
+// prev is the metadata of the node before the change. If re-creating fails after removing the node, it runs the node again with prev.
+func recreateGokiNode(m *gokiMetadata, prev gokiNodeMetadata, n gokiNodeMetadata) error {
+	return runGokiPhase("Re-creating "+n.Name, gokiRecreateTimeout, func() error {
+		return recreateGokiNodeContainer(m, prev, n)
+	})
+}
+
+// recreateGokiNodeContainer() re-creates the node with n. If it fails after removing the node, it restores the node with prev.
+func recreateGokiNodeContainer(m *gokiMetadata, prev gokiNodeMetadata, n gokiNodeMetadata) error {
+	fmt.Println("INFO: Re-creating " + n.Name + " start.")
+
+	// "docker stop" sends SIGTERM, so that the node drains gracefully.
+	c := gokiCommand("docker", "stop", "-t", strconv.Itoa(gokiSnapshotStopTimeout), n.Name)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker stop command that stopping %v failed.\n Error is: %v\n", n.Name, string(output))
+		return err
+	}
+	c = gokiCommand("docker", "rm", n.Name)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker rm command that removing %v failed.\n Error is: %v\n", n.Name, string(output))
+		return err
+	}
+
+	c = gokiCommand("docker", gokiNodeRunArgs(m, n)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker run command that re-creating %v failed.\n Error is: %v\n", n.Name, string(output))
+		runGokiCleanup(func() { restoreGokiNodeContainer(m, prev) })
+		return err
+	}
+
+	if err := waitSqlConnectionAcceptance(gokiResourceName+"-client", n.Name); err != nil {
+		runGokiCleanup(func() { restoreGokiNodeContainer(m, prev) })
+		return err
+	}
+	fmt.Println("INFO: Re-creating " + n.Name + " done.")
+	return nil
+}
+
+// restoreGokiNodeContainer() runs the node again with the settings before the change, after re-creating it with the new settings failed.
+// It does not wait for the node, because it is called in the cleanup.
+func restoreGokiNodeContainer(m *gokiMetadata, prev gokiNodeMetadata) {
+	fmt.Println("INFO: Restoring " + prev.Name + " with the previous settings start.")
+	gokiCommand("docker", "rm", "-f", prev.Name).Run()
+	c := gokiCommand("docker", gokiNodeRunArgs(m, prev)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker run command that restoring %v failed.\n Error is: %v\n", prev.Name, string(output))
+		fmt.Fprintf(os.Stderr, "HINT: Please re-create it from the metadata by \"goki revive -g %d\" command.\n", prev.Id)
+		return
+	}
+	fmt.Println("INFO: Restoring " + prev.Name + " done.")
+}
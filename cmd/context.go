@@ -0,0 +1,113 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeouts of each phase of "goki create" and the rolling restart of nodes.
+	gokiPullTimeout     time.Duration = 10 * time.Minute // Pulling the images of CockroachDB.
+	gokiResourceTimeout time.Duration = 1 * time.Minute  // Creating the docker network and volumes.
+	gokiCertsTimeout    time.Duration = 2 * time.Minute  // Creating the client container, cert files, and store key.
+	gokiNodesTimeout    time.Duration = 5 * time.Minute  // Starting nodes and waiting for SQL connections.
+	gokiSetupTimeout    time.Duration = 5 * time.Minute  // Launching the load balancer and loading intro DB.
+	gokiRecreateTimeout time.Duration = 3 * time.Minute  // Re-creating a node (includes the graceful shutdown).
+	gokiCleanupTimeout  time.Duration = 2 * time.Minute  // Removing the created resources after a failure or an interruption.
+)
+
+var (
+	gokiCtxMu   sync.Mutex
+	gokiRootCtx = context.Background() // Cancelled by SIGINT, SIGTERM, or --timeout.
+	gokiCtx     = context.Background() // Context of the current phase. The docker commands and SQL queries use it.
+)
+
+// gokiContext() returns the context that the docker commands and SQL queries of the current phase use.
+func gokiContext() context.Context {
+	gokiCtxMu.Lock()
+	defer gokiCtxMu.Unlock()
+	return gokiCtx
+}
+
+// setGokiContext() replaces the context of the current phase, and returns the function that restores the previous one.
+func setGokiContext(ctx context.Context) func() {
+	gokiCtxMu.Lock()
+	defer gokiCtxMu.Unlock()
+	prev := gokiCtx
+	gokiCtx = ctx
+	return func() {
+		gokiCtxMu.Lock()
+		defer gokiCtxMu.Unlock()
+		gokiCtx = prev
+	}
+}
+
+// gokiCommand() returns the command that is killed when Goki is interrupted or times out.
+func gokiCommand(name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(gokiContext(), name, args...)
+}
+
+// gokiSleep() waits for the duration. It returns an error, if Goki is interrupted or times out while waiting.
+func gokiSleep(d time.Duration) error {
+	ctx := gokiContext()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// runGokiPhase() runs the phase with its own timeout in addition to the global --timeout.
+func runGokiPhase(phase string, timeout time.Duration, f func() error) error {
+	parent := gokiContext()
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	restore := setGokiContext(ctx)
+	defer cancel()
+	defer restore()
+
+	err := f()
+	if err == nil && ctx.Err() == nil {
+		return nil
+	}
+	if parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(os.Stderr, "ERROR: %v timed out after %v.\n", phase, timeout)
+		return errors.New(phase + " timed out")
+	}
+	if parent.Err() != nil {
+		// Interrupted or timed out (--timeout). Execute() shows which one.
+		return parent.Err()
+	}
+	return err
+}
+
+// runGokiCleanup() runs the cleanup with a fresh context, so that it works even after Goki is interrupted or times out.
+// Another SIGINT or SIGTERM during the cleanup terminates Goki immediately.
+func runGokiCleanup(f func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), gokiCleanupTimeout)
+	restore := setGokiContext(ctx)
+	defer cancel()
+	defer restore()
+	f()
+}
@@ -18,28 +18,76 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/spf13/cobra"
 )
 
 var (
-	gokiVolumeAlreadyExist bool     // If Goki's docker volume already exist, re-use it and skip cluster initializing.
-	gokiRegionId           int  = 0 // The ID of a region that a node will be deployed. Goki uses region-1, region-2, or region-3.
-	gokiZoneId             int  = 0 // The ID of a zone that a node will be deployed. Goki uses zone-1, zone-2, or zone-3.
+	gokiVolumeAlreadyExist bool             // If Goki's docker volume already exist, re-use it and skip cluster initializing.
+	gokiNodeVersions       map[int]string   // Versions of CockroachDB of the nodes that --node-version specified (key is node ID).
+	gokiStartFlags         map[int][]string // Extra flags of "cockroach start" that --start-flag specified (key is node ID, 0 means all nodes).
+	gokiEnv                map[int][]string // Environment variables that --env specified (key is node ID, 0 means all nodes).
+	gokiCpus               map[int]string   // Number of CPUs that --cpus specified (key is node ID, 0 means all nodes).
+	gokiMemory             map[int]string   // Memory limits that --memory specified (key is node ID, 0 means all nodes).
 )
 
+// Flags of "cockroach start" that Goki manages. Users cannot override them by --start-flag.
+var gokiManagedStartFlags = []string{"--certs-dir", "--insecure", "--join", "--locality", "--external-io-dir", "--listen-addr", "--advertise-addr"}
+
+// Max number of stores per node.
+const gokiMaxStores int = 8
+
+// Format of the value of --start-flag and --env flag for the specific node (e.g. 3=--cache=.25).
+var gokiNodeOptionRegexp = regexp.MustCompile(`^(\d+)=(.*)$`)
+
+// Format of the version of CockroachDB (e.g. v23.2.4). Major release is "v23.2".
+var crdbVersionRegexp = regexp.MustCompile(`^v(\d+)\.(\d+)\.\d+`)
+
 // Flag value of create command.
 var createCmdFlags struct {
-	node        int    // Number of node (container).
-	crdbVersion string // Version of CockroachDB that specified to tag of container image.
-	locality    bool   // Whether set --locality flag or not.
+	node              int      // Number of node (container).
+	crdbVersion       string   // Version of CockroachDB that specified to tag of container image.
+	locality          bool     // Whether set --locality flag or not.
+	rootPassword      string   // Password of Root user.
+	nonRootUserName   string   // Name of Non-root user.
+	nonRootPassword   string   // Password of Non-root user.
+	generatePasswords bool     // Whether generate random passwords or not.
+	lb                bool     // Whether launch the load balancer or not.
+	image             string   // Container image of CockroachDB (overrides --crdb-version).
+	binary            string   // Absolute path of the locally built cockroach binary that is mounted into the container.
+	nodeVersions      []string // Versions of CockroachDB of the specific nodes (e.g. 3=v24.1.0).
+	startFlags        []string // Extra flags of "cockroach start" for all nodes (e.g. --cache=.25) or the specific node (e.g. 3=--attrs=ssd).
+	env               []string // Environment variables for all nodes (e.g. COCKROACH_X=1) or the specific node (e.g. 3=COCKROACH_X=1).
+	cpus              []string // Number of CPUs for all nodes (e.g. 1) or the specific node (e.g. 3=0.5).
+	memory            []string // Memory limit for all nodes (e.g. 2g) or the specific node (e.g. 3=512m).
+	stores            int      // Number of stores per node.
+	memStore          string   // Store spec of in-memory stores (e.g. type=mem,size=1GiB). If it is set, Goki does not create volumes for nodes.
+	encryption        bool     // Whether enable encryption at rest or not.
+	encryptionKeySize int      // Size of AES key of the store key.
+	keepOnFailure     bool     // If true, keep the created resources when "goki create" fails (for debugging).
+}
+
+// Resources that the current "goki create" created. If "goki create" fails, Goki removes them.
+type gokiCreatedResources struct {
+	containers []string      // Names of the created containers.
+	network    string        // Name of the created network.
+	volumes    []string      // Names of the created volumes. The volumes that already existed are not included.
+	metadata   bool          // Whether the metadata was saved.
+	prevMeta   *gokiMetadata // Metadata before "goki create" saved it (nil if it did not exist).
 }
 
+// Resources that the current "goki create" created. It is nil in other commands.
+var gokiCreated *gokiCreatedResources
+
 // createCmd represents the create command
 var createCmd = &cobra.Command{
 	Use:   "create",
@@ -51,6 +99,37 @@ var createCmd = &cobra.Command{
     goki create -n 9
 * You can specify the version of CockroachDB with --crdb-version flag.
     goki create --crdb-version v21.2.7
+* You can specify the credentials of users with --root-password, --non-root-user, and --non-root-password flag.
+  You can also specify them with GOKI_ROOT_PASSWORD, GOKI_NON_ROOT_USER, and GOKI_NON_ROOT_PASSWORD environment variable.
+    goki create --non-root-user foo --non-root-password foopass
+* You can generate random passwords with --generate-passwords flag. Goki stores them with the cluster metadata.
+    goki create --generate-passwords
+* You can use a custom image (e.g. your registry's mirror) with --image flag.
+    goki create --image registry.local/cockroach:custom
+* You can run a locally built cockroach binary (Linux) in the stock image with --binary flag.
+    goki create --binary ./cockroach
+* You can create a mixed-version cluster with --node-version flag, to test compatibility during upgrades.
+  The versions must be in adjacent major releases, and the first node must run the older one.
+    goki create -n 4 --crdb-version v23.2.4 --node-version 3=v24.1.0 --node-version 4=v24.1.0
+* You can pass extra flags of "cockroach start" and environment variables to all nodes or the specific node (<node ID>=<value>),
+  to reproduce customer configurations. Goki stores them in the metadata.
+    goki create --start-flag --cache=.25 --start-flag 3=--attrs=ssd --env COCKROACH_SKIP_ENABLING_DIAGNOSTIC_REPORTING=true
+* You can limit CPU and memory of all nodes or the specific node (<node ID>=<value>).
+  You can update the limits of the running nodes by "goki resources set" command.
+    goki create -n 9 --cpus 0.5 --memory 1g --memory 3=512m
+* You can create multiple stores per node with --stores flag. Each store has its own volume.
+    goki create --stores 2
+* You can create an ephemeral cluster with in-memory stores (without volumes of nodes) with --store flag.
+    goki create --store type=mem,size=1GiB
+* You can enable encryption at rest with --encryption flag. Goki generates the store key in the certs volume.
+  You can rotate the store key by "goki encryption rotate" command.
+    goki create --encryption --encryption-key-size 256
+* You can launch the load balancer (HAProxy) in front of all nodes with --lb flag.
+  Host applications can keep connecting via the load balancer, even if some nodes are killed by "goki jet".
+    goki create --lb
+* If creating the cluster fails, Goki removes the containers, network, and volumes that it created (the existing volumes are kept).
+  You can keep them for debugging with --keep-on-failure flag.
+    goki create --keep-on-failure
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -59,6 +138,37 @@ var createCmd = &cobra.Command{
 			return err
 		}
 
+		// Check the values of --image and --binary flag.
+		if err := checkCrdbImageAndBinary(cmd); err != nil {
+			return err
+		}
+
+		// Check the values of --node-version flag.
+		if err := checkGokiNodeVersions(); err != nil {
+			return err
+		}
+
+		// Check the values of --start-flag, --env, --cpus, and --memory flag.
+		if err := checkGokiNodeOptions(); err != nil {
+			return err
+		}
+
+		// Check the images of CockroachDB (and the load balancer) are available before creating any resources.
+		if err := runGokiPhase("Pulling images", gokiPullTimeout, func() error {
+			images := gokiCrdbImages()
+			if createCmdFlags.lb {
+				images = append(images, gokiHaproxyImage)
+			}
+			for _, image := range images {
+				if err := prepareCrdbImage(image); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
 		// Check the already running Goki Container.
 		if err := checkGokiContainer(); err != nil {
 			return err
@@ -74,18 +184,58 @@ var createCmd = &cobra.Command{
 			return err
 		}
 
+		// Set the stores of the existing data, and check the values of --stores and --store flag.
+		if err := setGokiStores(cmd); err != nil {
+			return err
+		}
+		if err := checkGokiStores(); err != nil {
+			return err
+		}
+
 		// Check the value of --node flag (number of cockroaches).
 		if err := checkNumOfNode(); err != nil {
 			return err
 		}
 
+		// Set the credentials of Root user and Non-root user.
+		if err := setGokiCredential(cmd); err != nil {
+			return err
+		}
+
+		// Set the store key of encryption at rest, if --encryption specified or the existing data is encrypted.
+		if err := setGokiEncryption(); err != nil {
+			return err
+		}
+
 		// Create CockroachDB Local Cluster.
-		fmt.Println("INFO: The number of cockroaches in the cluster is", createCmdFlags.node, ".")
-		if createCmdFlags.locality {
-			fmt.Println("INFO: The --set-locality is true. Set region and zone information in each node.")
+		// If it fails, remove the resources that were created so far, so that the next "goki create" can run.
+		gokiCreated = &gokiCreatedResources{}
+		if err := createGoki(); err != nil {
+			if createCmdFlags.keepOnFailure {
+				fmt.Fprintln(os.Stderr, "HINT: The created resources are kept for debugging (--keep-on-failure). Please delete them by \"goki delete\" command.")
+			} else {
+				// Roll back even if Goki is interrupted or times out.
+				runGokiCleanup(gokiCreated.rollback)
+			}
+			return err
 		}
-		fmt.Println("INFO: *** Start Creating CockroachDB Local Cluster ***")
 
+		return nil
+	},
+}
+
+// createGoki() creates the resources of the cluster, and starts it.
+// The created resources are recorded to gokiCreated, so that Goki can remove them if it fails.
+func createGoki() error {
+	fmt.Println("INFO: The number of cockroaches in the cluster is", createCmdFlags.node, ".")
+	if createCmdFlags.locality {
+		fmt.Println("INFO: The --set-locality is true. Set region and zone information in each node.")
+	}
+	fmt.Println("INFO: *** Start Creating CockroachDB Local Cluster ***")
+
+	// Each phase has its own timeout, so that a hung docker daemon or node does not block Goki forever.
+	var m *gokiMetadata
+	if err := runGokiPhase("Creating docker network and volumes", gokiResourceTimeout, func() error {
 		// Create Docker Network that each cockroach and client will join.
 		if err := createGokiNetwork(); err != nil {
 			return err
@@ -95,7 +245,25 @@ var createCmd = &cobra.Command{
 		if err := createGokiVolume(); err != nil {
 			return err
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
 
+	// Store the cluster metadata (includes credentials) for other commands.
+	// The nodes are created from the metadata.
+	prev, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	}
+	m = newGokiMetadata()
+	if err := saveGokiMetadata(m); err != nil {
+		return err
+	}
+	gokiCreated.metadata = true
+	gokiCreated.prevMeta = prev
+
+	if err := runGokiPhase("Creating client container and cert files", gokiCertsTimeout, func() error {
 		// Create client container as a client of CockroachDB Cluster.
 		// And, this container will be used to create cert files.
 		if err := createClientContainer(); err != nil {
@@ -107,13 +275,23 @@ var createCmd = &cobra.Command{
 			return err
 		}
 
+		// Create the store key in the certs volume.
+		if err := createGokiEncryptionKey(m.Encryption); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := runGokiPhase("Starting nodes", gokiNodesTimeout, func() error {
 		// Create first node.
-		if err := createFirstGoki(); err != nil {
+		if err := createFirstGoki(m); err != nil {
 			return err
 		}
 
 		// Create Cluster (Run containers).
-		if err := createGokiCluster(); err != nil {
+		if err := createGokiCluster(m); err != nil {
 			return err
 		}
 
@@ -121,6 +299,18 @@ var createCmd = &cobra.Command{
 		if err := checkSqlConnectionAcceptance(); err != nil {
 			return err
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := runGokiPhase("Setting up the cluster", gokiSetupTimeout, func() error {
+		// Launch the load balancer in front of all nodes, if --lb specified.
+		if createCmdFlags.lb {
+			if err := createGokiLb(gokiLbSqlPort, gokiLbWebUiPort); err != nil {
+				return err
+			}
+		}
 
 		// Confrim and show the Cluster Status by "cockroach node status" command.
 		if err := confirmClusterStatus(); err != nil {
@@ -139,16 +329,19 @@ var createCmd = &cobra.Command{
 		if err := showCockroach(); err != nil {
 			return err
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
 
-		// Show the way to access DB and Web UI.
-		showAccessCommand()
+	// Show the way to access DB and Web UI.
+	showAccessCommand()
 
-		return nil
-	},
+	return nil
 }
 
 func checkDocker() error {
-	c := exec.Command("docker", "version")
+	c := gokiCommand("docker", "version")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintln(os.Stderr, "ERROR: Goki needs Docker. Please install Docker.")
@@ -159,7 +352,7 @@ func checkDocker() error {
 }
 
 func checkGokiContainer() error {
-	c := exec.Command("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.ID}} : {{.Names}}")
+	c := gokiCommand("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.ID}} : {{.Names}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps commad failed: %v\n", string(output))
@@ -175,7 +368,7 @@ func checkGokiContainer() error {
 }
 
 func checkGokiNetwork() error {
-	c := exec.Command("docker", "network", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.ID}} : {{.Name}}")
+	c := gokiCommand("docker", "network", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.ID}} : {{.Name}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker network ls commad failed: %v\n", string(output))
@@ -190,7 +383,7 @@ func checkGokiNetwork() error {
 }
 
 func checkGokiVolume() error {
-	c := exec.Command("docker", "volume", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
+	c := gokiCommand("docker", "volume", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker volume ls command failed: %v\n", string(output))
@@ -212,10 +405,472 @@ func checkNumOfNode() error {
 	return nil
 }
 
+func setGokiCredential(cmd *cobra.Command) error {
+	// If the cluster is already initialized, the users and passwords are already set in the existing data.
+	// So, re-use the credentials in the metadata.
+	if gokiVolumeAlreadyExist {
+		if cmd.Flags().Changed("root-password") || cmd.Flags().Changed("non-root-user") ||
+			cmd.Flags().Changed("non-root-password") || createCmdFlags.generatePasswords {
+			fmt.Println("INFO: The Cluster is already initialized. Ignore the specified credentials and use the existing ones.")
+		}
+		return loadGokiCredential()
+	}
+
+	// Priority: flag > environment variable > default value.
+	overrideGokiCredentialByEnv()
+	if cmd.Flags().Changed("root-password") {
+		gokiCred.RootPassword = createCmdFlags.rootPassword
+	}
+	if cmd.Flags().Changed("non-root-user") {
+		gokiCred.NonRootUserName = createCmdFlags.nonRootUserName
+	}
+	if cmd.Flags().Changed("non-root-password") {
+		gokiCred.NonRootPassword = createCmdFlags.nonRootPassword
+	}
+
+	if createCmdFlags.generatePasswords {
+		if cmd.Flags().Changed("root-password") || cmd.Flags().Changed("non-root-password") {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --generate-passwords flag cannot be used with --root-password or --non-root-password flag.")
+			return errors.New("invalid argument. The --generate-passwords flag cannot be used with --root-password or --non-root-password flag")
+		}
+		var err error
+		if gokiCred.RootPassword, err = generatePassword(); err != nil {
+			return err
+		}
+		if gokiCred.NonRootPassword, err = generatePassword(); err != nil {
+			return err
+		}
+		fmt.Println("INFO: The --generate-passwords is true. Generated random passwords for Root user and Non-root user.")
+	}
+
+	if gokiCred.RootPassword == "" || gokiCred.NonRootPassword == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The password of Root user and Non-root user must not be empty.")
+		return errors.New("invalid argument. The password of Root user and Non-root user must not be empty")
+	}
+	if gokiCred.NonRootUserName == "" || gokiCred.NonRootUserName == "root" {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The name of Non-root user must not be empty or \"root\".")
+		return errors.New("invalid argument. The name of Non-root user must not be empty or \"root\"")
+	}
+	return nil
+}
+
+func checkCrdbImageAndBinary(cmd *cobra.Command) error {
+	if createCmdFlags.image != "" && cmd.Flags().Changed("crdb-version") {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --image flag cannot be used with --crdb-version flag.")
+		fmt.Fprintln(os.Stderr, "HINT: Please specify the tag in the value of --image flag (e.g. registry.local/cockroach:v23.2.4).")
+		return errors.New("invalid argument. The --image flag cannot be used with --crdb-version flag")
+	}
+
+	if createCmdFlags.binary == "" {
+		return nil
+	}
+
+	// Docker needs the absolute path to bind mount.
+	path, err := filepath.Abs(createCmdFlags.binary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting absolute path of %v failed.\n Error is: %v\n", createCmdFlags.binary, err)
+		return err
+	}
+	createCmdFlags.binary = path
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The binary %v does not exist.\n Error is: %v\n", path, err)
+		return err
+	} else if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The binary %v is not an executable file.\n", path)
+		return errors.New("invalid argument. The binary is not an executable file")
+	}
+
+	// The binary runs in the Linux container. So, it must be an ELF binary.
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Opening the binary %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "\x7fELF" {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The binary %v is not a Linux binary.\n", path)
+		fmt.Fprintln(os.Stderr, "HINT: Please build cockroach for Linux (e.g. \"./dev build --cross\" in the CockroachDB repository).")
+		return errors.New("invalid argument. The binary is not a Linux binary")
+	}
+	return nil
+}
+
+// gokiCrdbImage() returns the container image of CockroachDB that "goki create" uses.
+func gokiCrdbImage() string {
+	if createCmdFlags.image != "" {
+		return createCmdFlags.image
+	}
+	return crdbContainerImage + ":" + createCmdFlags.crdbVersion
+}
+
+// checkGokiNodeVersions() parses the values of --node-version flag, and checks that the versions of all nodes are in adjacent major releases.
+func checkGokiNodeVersions() error {
+	gokiNodeVersions = map[int]string{}
+	if len(createCmdFlags.nodeVersions) == 0 {
+		return nil
+	}
+
+	if createCmdFlags.binary != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --node-version flag cannot be used with --binary flag.")
+		return errors.New("invalid argument. The --node-version flag cannot be used with --binary flag")
+	}
+
+	for _, v := range createCmdFlags.nodeVersions {
+		f := strings.SplitN(v, "=", 2)
+		id, err := strconv.Atoi(f[0])
+		if len(f) != 2 || err != nil || !crdbVersionRegexp.MatchString(f[1]) {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The value of --node-version flag %v is not <node ID>=<version> (e.g. 3=v24.1.0).\n", v)
+			return errors.New("invalid argument. The value of --node-version flag is invalid")
+		} else if id < 1 || createCmdFlags.node < id {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The cluster has %d nodes. Please specify the node ID between 1 and %d in --node-version flag.\n", createCmdFlags.node, createCmdFlags.node)
+			return errors.New("invalid argument. The node of --node-version flag does not exist")
+		}
+		gokiNodeVersions[id] = f[1]
+	}
+
+	// The versions are compared by the major release (e.g. v23.2).
+	majors := map[[2]int]bool{}
+	for i := 1; i <= createCmdFlags.node; i++ {
+		major, ok := crdbMajorVersion(gokiImageTag(gokiNodeCrdbImage(i)))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The version of the node %d (%v) is not a release version of CockroachDB.\n", i, gokiImageTag(gokiNodeCrdbImage(i)))
+			fmt.Fprintln(os.Stderr, "HINT: Goki cannot check the compatibility of the versions. Please use the release versions (e.g. v23.2.4).")
+			return errors.New("invalid argument. The version is not a release version of CockroachDB")
+		}
+		majors[major] = true
+	}
+	if len(majors) == 1 {
+		return nil
+	}
+
+	first, _ := crdbMajorVersion(gokiImageTag(gokiNodeCrdbImage(1)))
+	for major := range majors {
+		if major == first {
+			continue
+		}
+		if len(majors) > 2 || !isAdjacentCrdbMajorVersion(first, major) {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The versions of the nodes must be in two adjacent major releases, and the first node must run the older one.")
+			fmt.Fprintln(os.Stderr, "HINT: The cluster is initialized by the first node. So, the nodes of the newer version join the cluster of the older version, as in a rolling upgrade.")
+			return errors.New("invalid argument. The versions of the nodes are not in adjacent major releases")
+		}
+	}
+	return nil
+}
+
+// checkGokiNodeOptions() parses the values of --start-flag, --env, --cpus, and --memory flag.
+// The value for the specific node has the prefix "<node ID>=". The others are for all nodes.
+func checkGokiNodeOptions() error {
+	gokiStartFlags = map[int][]string{}
+	gokiEnv = map[int][]string{}
+
+	for _, v := range createCmdFlags.startFlags {
+		id, flag, err := parseGokiNodeOption("--start-flag", v)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(flag, "-") {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The value of --start-flag flag %v is not a flag of cockroach start (e.g. --cache=.25).\n", v)
+			return errors.New("invalid argument. The value of --start-flag flag is not a flag")
+		}
+		name := strings.SplitN(flag, "=", 2)[0]
+		for _, managed := range gokiManagedStartFlags {
+			if name == managed {
+				fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The flag %v is managed by Goki, and cannot be specified by --start-flag flag.\n", name)
+				return errors.New("invalid argument. The flag is managed by Goki")
+			}
+		}
+		gokiStartFlags[id] = append(gokiStartFlags[id], flag)
+	}
+
+	for _, v := range createCmdFlags.env {
+		id, env, err := parseGokiNodeOption("--env", v)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(env, "COCKROACH_") || !strings.Contains(env, "=") {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The value of --env flag %v is not COCKROACH_<name>=<value>.\n", v)
+			return errors.New("invalid argument. The value of --env flag is invalid")
+		}
+		gokiEnv[id] = append(gokiEnv[id], env)
+	}
+
+	// The limit for the node overrides the one for all nodes.
+	gokiCpus = map[int]string{}
+	for _, v := range createCmdFlags.cpus {
+		id, cpus, err := parseGokiNodeOption("--cpus", v)
+		if err != nil {
+			return err
+		} else if err := checkGokiResourceLimit(cpus, "", false); err != nil {
+			return err
+		}
+		gokiCpus[id] = cpus
+	}
+	gokiMemory = map[int]string{}
+	for _, v := range createCmdFlags.memory {
+		id, memory, err := parseGokiNodeOption("--memory", v)
+		if err != nil {
+			return err
+		} else if err := checkGokiResourceLimit("", memory, false); err != nil {
+			return err
+		}
+		gokiMemory[id] = memory
+	}
+	return nil
+}
+
+// parseGokiNodeOption() returns the node ID (0 means all nodes) and the value without the prefix "<node ID>=".
+func parseGokiNodeOption(flag string, v string) (int, string, error) {
+	m := gokiNodeOptionRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return 0, v, nil
+	}
+	id, _ := strconv.Atoi(m[1])
+	if id < 1 || createCmdFlags.node < id {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The cluster has %d nodes. Please specify the node ID between 1 and %d in %v flag.\n", createCmdFlags.node, createCmdFlags.node, flag)
+		return 0, "", errors.New("invalid argument. The node of " + flag + " flag does not exist")
+	}
+	return id, m[2], nil
+}
+
+// gokiNodeStartFlags() returns the extra flags of "cockroach start" of the node i. The flags for the node follow the ones for all nodes.
+func gokiNodeStartFlags(i int) []string {
+	return append(append([]string{}, gokiStartFlags[0]...), gokiStartFlags[i]...)
+}
+
+// gokiNodeCpus() returns the number of CPUs of the node i. The empty string means unlimited.
+func gokiNodeCpus(i int) string {
+	if cpus, ok := gokiCpus[i]; ok {
+		return cpus
+	}
+	return gokiCpus[0]
+}
+
+// gokiNodeMemory() returns the memory limit of the node i. The empty string means unlimited.
+func gokiNodeMemory(i int) string {
+	if memory, ok := gokiMemory[i]; ok {
+		return memory
+	}
+	return gokiMemory[0]
+}
+
+// gokiNodeEnv() returns the environment variables of the node i. The variables for the node follow the ones for all nodes.
+func gokiNodeEnv(i int) []string {
+	return append(append([]string{}, gokiEnv[0]...), gokiEnv[i]...)
+}
+
+// setGokiStores() sets the number of stores and the in-memory store spec of the existing data, like the credentials and the store keys.
+// If Goki started the existing data with fewer stores, the data in the other store volumes would be lost.
+// So, --stores flag that disagrees with the existing data is an error.
+func setGokiStores(cmd *cobra.Command) error {
+	if !gokiVolumeAlreadyExist {
+		return nil
+	}
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	}
+
+	// The data that was created by old Goki (without metadata) has a store per node.
+	stores, memStore := 1, ""
+	if m != nil {
+		memStore = m.MemStore
+		if m.Stores > 1 {
+			stores = m.Stores
+		}
+	}
+
+	if memStore == "" {
+		if createCmdFlags.memStore != "" {
+			// The in-memory stores cannot re-use the existing data.
+			fmt.Fprintln(os.Stderr, "ERROR: The docker volumes of Goki already exist. The cluster with in-memory stores cannot re-use the existing data.")
+			fmt.Fprintln(os.Stderr, "HINT: Please delete the volumes by \"goki delete -v\" command.")
+			return errors.New("the docker volumes of Goki already exist")
+		}
+		if cmd.Flags().Changed("stores") && createCmdFlags.stores != stores {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The existing data has %d stores per node, but --stores flag is %d.\n", stores, createCmdFlags.stores)
+			fmt.Fprintln(os.Stderr, "HINT: Please remove --stores flag to re-use the existing data, or delete the volumes by \"goki delete -v\" command.")
+			return errors.New("invalid argument. The number of stores does not match the existing data")
+		}
+		if createCmdFlags.stores != stores {
+			fmt.Printf("INFO: The existing data has %d stores per node. Use them.\n", stores)
+		}
+		createCmdFlags.stores = stores
+		return nil
+	}
+
+	// The existing cluster used in-memory stores, so there is no data of nodes to lose.
+	// Use the same store spec, unless --stores or --store flag is specified.
+	if !cmd.Flags().Changed("store") && !cmd.Flags().Changed("stores") {
+		fmt.Println("INFO: The existing cluster used in-memory stores (" + memStore + "). Use the same store spec.")
+		createCmdFlags.memStore = memStore
+	}
+	return nil
+}
+
+// checkGokiStores() checks the values of --stores and --store flag.
+func checkGokiStores() error {
+	if createCmdFlags.stores < 1 || gokiMaxStores < createCmdFlags.stores {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. Please specify the number of stores between 1 and %d.\n", gokiMaxStores)
+		return errors.New("invalid argument. The number of stores is invalid")
+	}
+
+	if createCmdFlags.memStore != "" {
+		spec := "," + createCmdFlags.memStore + ","
+		if !strings.Contains(spec, ",type=mem,") || !strings.Contains(spec, ",size=") {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The value of --store flag %v is not an in-memory store spec.\n", createCmdFlags.memStore)
+			fmt.Fprintln(os.Stderr, "HINT: Please specify type=mem and size (e.g. type=mem,size=1GiB). For multiple on-disk stores, please use --stores flag.")
+			return errors.New("invalid argument. The value of --store flag is not an in-memory store spec")
+		}
+	}
+
+	// Goki manages --store flag, if the node has multiple stores, in-memory stores, or encrypted stores.
+	if createCmdFlags.stores > 1 || createCmdFlags.memStore != "" || createCmdFlags.encryption {
+		for _, flags := range gokiStartFlags {
+			for _, flag := range flags {
+				if strings.HasPrefix(flag, "--store=") || flag == "--store" || strings.HasPrefix(flag, "-s=") {
+					fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --store flag of cockroach start cannot be specified by --start-flag flag with --stores or --store flag.")
+					return errors.New("invalid argument. The --store flag of cockroach start is managed by Goki")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// gokiStoreVolumes() returns the volumes of the stores of the node i in the cluster (prefix is the resource name).
+// The first store uses <prefix>-volume-<i> as before, and the other stores use <prefix>-volume-<i>-<k>.
+// If the cluster uses in-memory stores, it returns nil.
+func gokiStoreVolumes(prefix string, i int) []string {
+	return gokiStoreVolumesOf(prefix, i, createCmdFlags.stores, createCmdFlags.memStore)
+}
+
+func gokiStoreVolumesOf(prefix string, i int, stores int, memStore string) []string {
+	if memStore != "" {
+		return nil
+	}
+	volumes := []string{prefix + "-volume-" + strconv.Itoa(i)}
+	for k := 2; k <= stores; k++ {
+		volumes = append(volumes, prefix+"-volume-"+strconv.Itoa(i)+"-"+strconv.Itoa(k))
+	}
+	return volumes
+}
+
+// gokiStorePath() returns the path of the store k in the node. The first store is the default path of CockroachDB.
+func gokiStorePath(k int) string {
+	if k == 1 {
+		return "/cockroach/cockroach-data"
+	}
+	return "/cockroach/cockroach-data-" + strconv.Itoa(k)
+}
+
+// gokiStoreFlags() returns the --store (and --enterprise-encryption) flags of "cockroach start".
+// A single on-disk store without encryption uses the default path without the flag.
+func gokiStoreFlags(stores int, memStore string, e *gokiEncryptionMetadata) []string {
+	flags := []string{}
+	if memStore != "" {
+		for k := 1; k <= stores; k++ {
+			flags = append(flags, "--store="+memStore)
+		}
+	} else if stores > 1 || e != nil {
+		// The --enterprise-encryption flag refers to the store by its path. So, specify the path of the single store explicitly.
+		flags = append(flags, "--store=path="+gokiStorePath(1))
+		for k := 2; k <= stores; k++ {
+			flags = append(flags, "--store=path="+gokiStorePath(k))
+		}
+	}
+	if e != nil {
+		flags = append(flags, gokiEncryptionFlags(e, stores)...)
+	}
+	return flags
+}
+
+// crdbMajorVersion() returns the major release of the version (e.g. [23, 2] of v23.2.4).
+func crdbMajorVersion(version string) ([2]int, bool) {
+	m := crdbVersionRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return [2]int{}, false
+	}
+	year, _ := strconv.Atoi(m[1])
+	release, _ := strconv.Atoi(m[2])
+	return [2]int{year, release}, true
+}
+
+// isAdjacentCrdbMajorVersion() checks that the newer major release can be upgraded from the older one directly.
+// It is the next release of the older one (e.g. v23.1 -> v23.2, v23.2 -> v24.1).
+// A regular release can also skip the next innovation release (e.g. v24.1 -> v24.3), but an innovation release cannot (e.g. v24.2 -> v25.1).
+func isAdjacentCrdbMajorVersion(older, newer [2]int) bool {
+	next := nextCrdbMajorVersion(older)
+	if newer == next {
+		return true
+	}
+	return !isCrdbInnovationRelease(older) && isCrdbInnovationRelease(next) && newer == nextCrdbMajorVersion(next)
+}
+
+// nextCrdbMajorVersion() returns the major release after the version.
+// There are two releases a year until 2023, three in 2024, and four since 2025.
+func nextCrdbMajorVersion(v [2]int) [2]int {
+	releases := 2
+	if v[0] == 24 {
+		releases = 3
+	} else if v[0] >= 25 {
+		releases = 4
+	}
+	if v[1] < releases {
+		return [2]int{v[0], v[1] + 1}
+	}
+	return [2]int{v[0] + 1, 1}
+}
+
+// isCrdbInnovationRelease() checks that the major release is an innovation release (v24.2, and v25.1, v25.3, ... since 2025).
+func isCrdbInnovationRelease(v [2]int) bool {
+	return v == [2]int{24, 2} || (v[0] >= 25 && v[1]%2 == 1)
+}
+
+// gokiNodeCrdbImage() returns the container image of CockroachDB of the node i.
+// The node that --node-version specified uses the same repository as the other nodes with the version tag.
+func gokiNodeCrdbImage(i int) string {
+	v, ok := gokiNodeVersions[i]
+	if !ok {
+		return gokiCrdbImage()
+	}
+	image := gokiCrdbImage()
+	if j := strings.LastIndex(image, ":"); j > strings.LastIndex(image, "/") {
+		image = image[:j]
+	}
+	return image + ":" + v
+}
+
+// gokiCrdbImages() returns the distinct container images of CockroachDB that all nodes use.
+func gokiCrdbImages() []string {
+	images := []string{gokiCrdbImage()}
+	for i := 1; i <= createCmdFlags.node; i++ {
+		image := gokiNodeCrdbImage(i)
+		found := false
+		for _, img := range images {
+			found = found || img == image
+		}
+		if !found {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// gokiBinaryMounts() returns the option of "docker run" that mounts the locally built cockroach binary, if it is specified.
+func gokiBinaryMounts(binary string) []string {
+	if binary == "" {
+		return nil
+	}
+	return []string{"--mount=type=bind,src=" + binary + ",dst=/cockroach/cockroach,readonly"}
+}
+
 func createGokiNetwork() error {
 	fmt.Println("INFO: Creating Docker Network " + gokiResourceName + "-net start.")
 
-	c := exec.Command("docker", "network", "create", "-d", "bridge", gokiResourceName+"-net",
+	c := gokiCommand("docker", "network", "create", "-d", "bridge", gokiResourceName+"-net",
 		"-o", "com.docker.network.bridge.name="+gokiResourceName+"-net",
 		"--label="+gokiResourceLabel)
 
@@ -223,6 +878,7 @@ func createGokiNetwork() error {
 		fmt.Fprintf(os.Stderr, "ERROR: docker network create command failed.\n Error is: %v\n", string(output))
 		return err
 	} else {
+		gokiCreated.addNetwork(gokiResourceName + "-net")
 		fmt.Printf("INFO: Created docker network is: %s", string(output))
 		fmt.Println("INFO: Creating Docker Network " + gokiResourceName + "-net done.")
 	}
@@ -233,26 +889,53 @@ func createGokiVolume() error {
 	fmt.Println("INFO: Creating Docker Volume start.")
 
 	// For client container. This volume includes cert files.
-	c := exec.Command("docker", "volume", "create", gokiResourceName+"-volume-client",
+	volumeExists := gokiVolumeExists(gokiResourceName + "-volume-client")
+	c := gokiCommand("docker", "volume", "create", gokiResourceName+"-volume-client",
 		"--label="+gokiResourceLabel)
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: docker volume create command failed.\n Error is: %v\n", string(output))
 		return err
 	} else {
+		if !volumeExists {
+			gokiCreated.addVolume(gokiResourceName + "-volume-client")
+		}
+		fmt.Printf("INFO: Created docker volume is: %s", string(output))
+	}
+
+	// For backups. It has a different label from other resources, so that backups survive "goki delete -v"
+	// and can be restored into a freshly created cluster.
+	volumeExists = gokiVolumeExists(gokiResourceName + "-backup")
+	c = gokiCommand("docker", "volume", "create", gokiResourceName+"-backup",
+		"--label="+gokiBackupLabel)
+
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker volume create command failed.\n Error is: %v\n", string(output))
+		return err
+	} else {
+		if !volumeExists {
+			gokiCreated.addVolume(gokiResourceName + "-backup")
+		}
 		fmt.Printf("INFO: Created docker volume is: %s", string(output))
 	}
 
-	// For each cockroach. This volume includes data file of DB.
+	// For each store of each cockroach. This volume includes data file of DB.
+	// The in-memory stores do not have volumes.
 	for i := 1; i <= createCmdFlags.node; i++ {
-		c := exec.Command("docker", "volume", "create", gokiResourceName+"-volume-"+strconv.Itoa(i),
-			"--label="+gokiResourceLabel)
+		for _, volume := range gokiStoreVolumes(gokiResourceName, i) {
+			volumeExists := gokiVolumeExists(volume)
+			c := gokiCommand("docker", "volume", "create", volume,
+				"--label="+gokiResourceLabel)
 
-		if output, err := c.CombinedOutput(); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: docker volume create command failed.\n Error is: %v\n", string(output))
-			return err
-		} else {
-			fmt.Printf("INFO: Created docker volume is: %s", string(output))
+			if output, err := c.CombinedOutput(); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: docker volume create command failed.\n Error is: %v\n", string(output))
+				return err
+			} else {
+				if !volumeExists {
+					gokiCreated.addVolume(volume)
+				}
+				fmt.Printf("INFO: Created docker volume is: %s", string(output))
+			}
 		}
 	}
 
@@ -263,16 +946,23 @@ func createGokiVolume() error {
 func createClientContainer() error {
 	fmt.Println("INFO: Creating client container start.")
 
-	c := exec.Command("docker", "run", "-d",
-		"--name="+gokiResourceName+"-client",
-		"--hostname="+gokiResourceName+"-client",
-		"--network="+gokiResourceName+"-net",
-		"--mount=type=volume,src="+gokiResourceName+"-volume-client,dst=/cockroach/certs",
+	// The client container also uses the custom binary, so that "cockroach sql" and "cockroach cert" match the nodes.
+	args := []string{"run", "-d",
+		"--name=" + gokiResourceName + "-client",
+		"--hostname=" + gokiResourceName + "-client",
+		"--network=" + gokiResourceName + "-net",
+		"--mount=type=volume,src=" + gokiResourceName + "-volume-client,dst=/cockroach/certs",
+	}
+	args = append(args, gokiBinaryMounts(createCmdFlags.binary)...)
+	args = append(args,
 		"--label="+gokiResourceLabel,
 		"--entrypoint=sleep",
-		crdbContainerImage+":"+createCmdFlags.crdbVersion,
+		gokiNodeCrdbImage(1),
 		"inf",
 	)
+	// "docker run" may leave the container (e.g. if it cannot start), so that record it before running.
+	gokiCreated.addContainer(gokiResourceName + "-client")
+	c := gokiCommand("docker", args...)
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: docker run command that creating "+gokiResourceName+"-client failed.\n Error is: %v\n", string(output))
@@ -289,7 +979,7 @@ func createCertFile() error {
 	fmt.Println("INFO: Creating cert files start.")
 
 	// Create CA cert dir.
-	c := exec.Command("docker", "exec", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"mkdir", "-p", "/cockroach/certs/node-certs/../.setup/my-safe-directory/")
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: docker exec command that creating certs dir failed.\n Error is: %v\n", string(output))
@@ -298,7 +988,7 @@ func createCertFile() error {
 
 	// Create cockroach (node) certs dir.
 	for i := 1; i <= createCmdFlags.node; i++ {
-		c = exec.Command("docker", "exec", gokiResourceName+"-client",
+		c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 			"mkdir", "-p", "/cockroach/certs/node-certs/"+gokiResourceName+"-"+strconv.Itoa(i))
 
 		if output, err := c.CombinedOutput(); err != nil {
@@ -308,7 +998,7 @@ func createCertFile() error {
 	}
 
 	// Create CA.
-	c = exec.Command("docker", "exec", gokiResourceName+"-client",
+	c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"./cockroach", "cert", "create-ca",
 		"--certs-dir=/cockroach/certs/.setup/cert-tmp",
 		"--ca-key=/cockroach/certs/.setup/my-safe-directory/ca.key",
@@ -323,7 +1013,7 @@ func createCertFile() error {
 
 	// Create cockroach (node) certs.
 	for i := 1; i <= createCmdFlags.node; i++ {
-		c := exec.Command("docker", "exec", gokiResourceName+"-client",
+		c := gokiCommand("docker", "exec", gokiResourceName+"-client",
 			"./cockroach", "cert", "create-node", gokiResourceName+"-"+strconv.Itoa(i), "localhost",
 			"--certs-dir=/cockroach/certs/.setup/cert-tmp",
 			"--ca-key=/cockroach/certs/.setup/my-safe-directory/ca.key",
@@ -335,7 +1025,7 @@ func createCertFile() error {
 			return err
 		}
 
-		c = exec.Command("docker", "exec", gokiResourceName+"-client",
+		c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 			"cp",
 			"/cockroach/certs/.setup/cert-tmp/ca.crt",
 			"/cockroach/certs/.setup/cert-tmp/node.crt",
@@ -350,7 +1040,7 @@ func createCertFile() error {
 	}
 
 	// Create client cert.
-	c = exec.Command("docker", "exec", gokiResourceName+"-client",
+	c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"./cockroach", "cert", "create-client", "root",
 		"--certs-dir=/cockroach/certs/.setup/cert-tmp",
 		"--ca-key=/cockroach/certs/.setup/my-safe-directory/ca.key",
@@ -362,7 +1052,7 @@ func createCertFile() error {
 		return err
 	}
 
-	c = exec.Command("docker", "exec", gokiResourceName+"-client",
+	c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"cp",
 		"/cockroach/certs/.setup/cert-tmp/ca.crt",
 		"/cockroach/certs/.setup/cert-tmp/client.root.crt",
@@ -379,30 +1069,71 @@ func createCertFile() error {
 	return nil
 }
 
-func createFirstGoki() error {
-	// Create first node.
-	fmt.Println("INFO: Creating First node start.")
+// gokiLocality() returns the value of --locality flag of the node i.
+// Goki deploys nodes to region-1, region-2, or region-3 and zone-1, zone-2, or zone-3 in order.
+// If locality is false, it returns region-0 and zone-0.
+func gokiLocality(i int, locality bool) string {
+	regionId := 0
+	zoneId := 0
 
-	if createCmdFlags.locality {
-		gokiRegionId = 1
-		gokiZoneId = 1
-	}
-
-	c := exec.Command("docker", "run", "-d",
-		"--name="+gokiResourceName+"-1",
-		"--hostname="+gokiResourceName+"-1",
-		"--network="+gokiResourceName+"-net",
-		"-p", gokiSqlIp+":"+gokiSqlPort+":26257",
-		"-p", gokiWebUiIp+":"+gokiWebUiPort+":8080",
-		"--mount=type=volume,src="+gokiResourceName+"-volume-client,dst=/cockroach/certs",
-		"--mount=type=volume,src="+gokiResourceName+"-volume-1,dst=/cockroach/cockroach-data",
+	if locality {
+		regionId = (i-1)/3 + 1
+		zoneId = (i-1)%3 + 1
+	}
+
+	return "region=region-" + strconv.Itoa(regionId) + ",zone=zone-" + strconv.Itoa(zoneId)
+}
+
+// gokiNodeRunArgs() returns the arguments of "docker run" command that creates the node n of the cluster m.
+// It depends on the metadata only, so that other commands can re-create the node in the same way as "goki create".
+func gokiNodeRunArgs(m *gokiMetadata, n gokiNodeMetadata) []string {
+	args := []string{"run", "-d",
+		"--name=" + n.Name,
+		"--hostname=" + n.Name,
+		"--network=" + gokiResourceName + "-net",
+	}
+	// Only the first node publishes its ports.
+	if n.SqlAddr != "" {
+		args = append(args, "-p", n.SqlAddr+":26257")
+	}
+	if n.WebUiAddr != "" {
+		args = append(args, "-p", n.WebUiAddr+":8080")
+	}
+	args = append(args, "--mount=type=volume,src="+gokiResourceName+"-volume-client,dst=/cockroach/certs")
+	for k, volume := range gokiStoreVolumesOf(gokiResourceName, n.Id, m.Stores, m.MemStore) {
+		args = append(args, "--mount=type=volume,src="+volume+",dst="+gokiStorePath(k+1))
+	}
+	args = append(args, "--mount=type=volume,src="+gokiResourceName+"-backup,dst="+gokiBackupDir)
+	args = append(args, gokiBinaryMounts(m.Binary)...)
+	for _, env := range n.Env {
+		args = append(args, "--env="+env)
+	}
+	args = append(args, gokiResourceLimitArgs(n.Cpus, n.Memory)...)
+	args = append(args, gokiDiskThrottleArgs(n.DiskThrottle)...)
+	image := m.Image
+	if n.Image != "" {
+		image = n.Image
+	}
+	args = append(args,
 		"--label="+gokiResourceLabel,
-		crdbContainerImage+":"+createCmdFlags.crdbVersion,
+		image,
 		"start",
-		"--certs-dir=certs/node-certs/"+gokiResourceName+"-1",
+		"--certs-dir=certs/node-certs/"+n.Name,
 		"--join="+gokiResourceName+"-1,"+gokiResourceName+"-2,"+gokiResourceName+"-3",
-		"--locality=region=region-"+strconv.Itoa(gokiRegionId)+",zone=zone-"+strconv.Itoa(gokiZoneId),
+		"--locality="+n.Locality,
+		"--external-io-dir="+gokiBackupDir,
 	)
+	args = append(args, gokiStoreFlags(m.Stores, m.MemStore, m.Encryption)...)
+	args = append(args, n.StartFlags...)
+	return args
+}
+
+func createFirstGoki(m *gokiMetadata) error {
+	// Create first node.
+	fmt.Println("INFO: Creating First node start.")
+
+	gokiCreated.addContainer(m.Nodes[0].Name)
+	c := gokiCommand("docker", gokiNodeRunArgs(m, m.Nodes[0])...)
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: Start first node failed.\n Error is: %v\n", string(output))
@@ -412,7 +1143,9 @@ func createFirstGoki() error {
 	}
 
 	// Wait for 1 second just in case, for waiting first node start before init cluster.
-	time.Sleep(time.Second * 1)
+	if err := gokiSleep(time.Second * 1); err != nil {
+		return err
+	}
 	fmt.Println("INFO: Creating First node done.")
 
 	// Init CockroachDB Cluster.
@@ -424,7 +1157,7 @@ func createFirstGoki() error {
 	if !gokiVolumeAlreadyExist {
 		fmt.Println("INFO: Initializing Cluster start.")
 
-		c = exec.Command("docker", "exec", gokiResourceName+"-client",
+		c = gokiCommand("docker", "exec", gokiResourceName+"-client",
 			"./cockroach", "init",
 			"--certs-dir=certs/",
 			"--host="+gokiResourceName+"-1:26257",
@@ -464,11 +1197,11 @@ func createFirstGoki() error {
 }
 
 func setRootPassword() error {
-	c := exec.Command("docker", "exec", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"./cockroach", "sql",
 		"--certs-dir=/cockroach/certs/",
 		"--host="+gokiResourceName+"-1:26257",
-		"-e", "ALTER USER root WITH PASSWORD '"+gokiRootUserPassword+"'",
+		"-e", "ALTER USER root WITH PASSWORD "+pq.QuoteLiteral(gokiCred.RootPassword),
 	)
 
 	if output, err := c.CombinedOutput(); err != nil {
@@ -479,11 +1212,11 @@ func setRootPassword() error {
 }
 
 func createNonRootUser() error {
-	c := exec.Command("docker", "exec", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
 		"./cockroach", "sql",
 		"--certs-dir=/cockroach/certs/",
 		"--host="+gokiResourceName+"-1:26257",
-		"-e", "CREATE USER IF NOT EXISTS "+gokiNonRootUserName+" WITH PASSWORD '"+gokiNonRootUserPassword+"'",
+		"-e", "CREATE USER IF NOT EXISTS "+pq.QuoteIdentifier(gokiCred.NonRootUserName)+" WITH PASSWORD "+pq.QuoteLiteral(gokiCred.NonRootPassword),
 	)
 
 	if output, err := c.CombinedOutput(); err != nil {
@@ -495,14 +1228,21 @@ func createNonRootUser() error {
 
 func checkGokiNode(g int) error {
 	// Connect to CockroachDB via PostgreSQL driver.
-	connStr := "postgresql://root:" + gokiRootUserPassword + "@localhost:26257/defaultdb?sslmode=require"
-	db, err := sql.Open("postgres", connStr)
+	connStr := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword("root", gokiCred.RootPassword),
+		Host:     "localhost:26257",
+		Path:     "/defaultdb",
+		RawQuery: "sslmode=require",
+	}
+	db, err := sql.Open("postgres", connStr.String())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Connecting to CockroachDB via PostgreSQL driver failed.\n")
 		return err
 	}
+	defer db.Close()
 	// Get internal ID and node name (address) from cluster meta data.
-	rows, err := db.Query("SELECT node_id, address FROM crdb_internal.gossip_nodes WHERE node_id = $1", g)
+	rows, err := db.QueryContext(gokiContext(), "SELECT node_id, address FROM crdb_internal.gossip_nodes WHERE node_id = $1", g)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Getting internal ID and node name (address) from cluster meta data failed.\n")
 		return err
@@ -526,42 +1266,14 @@ func checkGokiNode(g int) error {
 	return nil
 }
 
-func createGokiCluster() error {
+func createGokiCluster(m *gokiMetadata) error {
 	fmt.Println("INFO: Creating Cluster start.")
 
 	// Run the second and later node.
 	for i := 2; i <= createCmdFlags.node; i++ {
 
-		if createCmdFlags.locality {
-			switch i {
-			case 1, 2, 3:
-				gokiRegionId = 1
-			case 4, 5, 6:
-				gokiRegionId = 2
-			case 7, 8, 9:
-				gokiRegionId = 3
-			}
-
-			if i%3 == 0 {
-				gokiZoneId = 3
-			} else {
-				gokiZoneId = i % 3
-			}
-		}
-
-		c := exec.Command("docker", "run", "-d",
-			"--name="+gokiResourceName+"-"+strconv.Itoa(i),
-			"--hostname="+gokiResourceName+"-"+strconv.Itoa(i),
-			"--network="+gokiResourceName+"-net",
-			"--mount=type=volume,src="+gokiResourceName+"-volume-client,dst=/cockroach/certs",
-			"--mount=type=volume,src="+gokiResourceName+"-volume-"+strconv.Itoa(i)+",dst=/cockroach/cockroach-data",
-			"--label="+gokiResourceLabel,
-			crdbContainerImage+":"+createCmdFlags.crdbVersion,
-			"start",
-			"--certs-dir=certs/node-certs/"+gokiResourceName+"-"+strconv.Itoa(i),
-			"--join="+gokiResourceName+"-1,"+gokiResourceName+"-2,"+gokiResourceName+"-3",
-			"--locality=region=region-"+strconv.Itoa(gokiRegionId)+",zone=zone-"+strconv.Itoa(gokiZoneId),
-		)
+		gokiCreated.addContainer(m.Nodes[i-1].Name)
+		c := gokiCommand("docker", gokiNodeRunArgs(m, m.Nodes[i-1])...)
 
 		if output, err := c.CombinedOutput(); err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: Start second or later node failed.\n Error is: %v\n", string(output))
@@ -571,7 +1283,9 @@ func createGokiCluster() error {
 		}
 
 		// Wait for 1 second just in case, for waiting each node start before start next node.
-		time.Sleep(time.Second * 1)
+		if err := gokiSleep(time.Second * 1); err != nil {
+			return err
+		}
 
 		// If the Cluster already initialized, we don't need to check the node ID.
 		if !gokiVolumeAlreadyExist {
@@ -586,13 +1300,103 @@ func createGokiCluster() error {
 }
 
 func checkSqlConnectionAcceptance() error {
+	return waitSqlConnectionAcceptance(gokiResourceName+"-client", gokiResourceName+"-1")
+}
+
+// gokiVolumeExists() returns true, if the docker volume exists.
+func gokiVolumeExists(volume string) bool {
+	return gokiCommand("docker", "volume", "inspect", volume).Run() == nil
+}
+
+// addContainer() records the container that the current "goki create" created. It does nothing in other commands.
+func (r *gokiCreatedResources) addContainer(container string) {
+	if r != nil {
+		r.containers = append(r.containers, container)
+	}
+}
+
+func (r *gokiCreatedResources) addNetwork(network string) {
+	if r != nil {
+		r.network = network
+	}
+}
+
+func (r *gokiCreatedResources) addVolume(volume string) {
+	if r != nil {
+		r.volumes = append(r.volumes, volume)
+	}
+}
+
+// rollback() removes the resources that the current "goki create" created, in the reverse order.
+// It continues even if removing some resource fails, and shows the resources that are left.
+func (r *gokiCreatedResources) rollback() {
+	fmt.Println("INFO: *** Start Rolling Back the Created Resources ***")
+	left := []string{}
+
+	for i := len(r.containers) - 1; i >= 0; i-- {
+		c := gokiCommand("docker", "rm", "-f", r.containers[i])
+		if output, err := c.CombinedOutput(); err != nil {
+			if strings.Contains(string(output), "No such container") {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "ERROR: docker rm command that removing %v failed.\n Error is: %v\n", r.containers[i], string(output))
+			left = append(left, r.containers[i])
+			continue
+		}
+		fmt.Println("INFO: Removed container is: " + r.containers[i])
+	}
+
+	if r.network != "" {
+		c := gokiCommand("docker", "network", "rm", r.network)
+		if output, err := c.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker network rm command that removing %v failed.\n Error is: %v\n", r.network, string(output))
+			left = append(left, r.network)
+		} else {
+			fmt.Println("INFO: Removed docker network is: " + r.network)
+		}
+	}
+
+	// The volumes that already existed (e.g. the data of the previous cluster) are kept.
+	for i := len(r.volumes) - 1; i >= 0; i-- {
+		c := gokiCommand("docker", "volume", "rm", r.volumes[i])
+		if output, err := c.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker volume rm command that removing %v failed.\n Error is: %v\n", r.volumes[i], string(output))
+			left = append(left, r.volumes[i])
+			continue
+		}
+		fmt.Println("INFO: Removed docker volume is: " + r.volumes[i])
+	}
+
+	// Restore the metadata of the previous cluster, so that its store keys and credentials are not lost.
+	if r.metadata {
+		if r.prevMeta != nil {
+			if err := saveGokiMetadata(r.prevMeta); err != nil {
+				left = append(left, "metadata")
+			}
+		} else if err := deleteGokiMetadata(); err != nil {
+			left = append(left, "metadata")
+		}
+	}
+
+	if len(left) != 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: Rolling back failed. The following resources are left: "+strings.Join(left, ", "))
+		fmt.Fprintln(os.Stderr, "HINT: Please delete them by \"goki delete\" command.")
+		return
+	}
+	fmt.Println("INFO: *** Rolling Back done ***")
+}
+
+// waitSqlConnectionAcceptance() waits until the host accepts SQL connections from the client container.
+func waitSqlConnectionAcceptance(client string, host string) error {
 	for i := 0; i < 10; i++ {
-		time.Sleep(time.Second * 1)
+		if err := gokiSleep(time.Second * 1); err != nil {
+			return err
+		}
 
-		c := exec.Command("docker", "exec", gokiResourceName+"-client",
+		c := gokiCommand("docker", "exec", client,
 			"./cockroach", "sql",
 			"--certs-dir=/cockroach/certs/",
-			"--host="+gokiResourceName+"-1:26257",
+			"--host="+host+":26257",
 			"-e", "SELECT 1",
 		)
 
@@ -601,7 +1405,7 @@ func checkSqlConnectionAcceptance() error {
 				fmt.Println("INFO: CockroachDB is NOT ready to accept connections.")
 			} else {
 				fmt.Fprintf(os.Stderr, "ERROR: CockroachDB was NOT ready to accept connections, even if try to connect to DB 10 times (even if waiting about 10 second).\n Error is: %v\n", string(output))
-				fmt.Println("HINT: There is possibility that some error occurred. Please check the DB or Container log.")
+				fmt.Println("HINT: There is possibility that some error occurred. Please check the DB or Container log by \"goki logs --all\" command.")
 				return err
 			}
 		} else {
@@ -615,7 +1419,7 @@ func checkSqlConnectionAcceptance() error {
 func confirmClusterStatus() error {
 	fmt.Println("INFO: CockroachDB Cluster Status is the following.")
 
-	c := exec.Command("docker", "exec", "-t", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", "-t", gokiResourceName+"-client",
 		"./cockroach", "node", "status",
 		"--certs-dir=/cockroach/certs/",
 		"--host="+gokiResourceName+"-1:26257",
@@ -632,7 +1436,7 @@ func confirmClusterStatus() error {
 }
 
 func loadIntroDB() error {
-	c := exec.Command("docker", "exec", "-t", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", "-t", gokiResourceName+"-client",
 		"./cockroach", "workload", "init", "intro",
 		"postgresql://root@"+gokiResourceName+"-1:26257?sslcert=certs%2Fclient.root.crt&sslkey=certs%2Fclient.root.key&sslmode=verify-full&sslrootcert=certs%2Fca.crt",
 	)
@@ -646,7 +1450,7 @@ func loadIntroDB() error {
 }
 
 func showCockroach() error {
-	c := exec.Command("docker", "exec", "-t", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", "-t", gokiResourceName+"-client",
 		"./cockroach", "sql",
 		"--certs-dir=/cockroach/certs/",
 		"--host="+gokiResourceName+"-1:26257",
@@ -675,11 +1479,17 @@ func showAccessCommand() {
 	fmt.Printf("    or\n")
 	fmt.Printf("  goki sql -u <user name> -p <password>\n")
 
-	fmt.Printf("\nAccess Web UI as a root user (User: root / Password: %v):\n", gokiRootUserPassword)
+	fmt.Printf("\nAccess Web UI as a root user (User: root / Password: %v):\n", gokiCred.RootPassword)
+	fmt.Printf("  URL: https://%v:%v/\n", gokiWebUiIp, gokiWebUiPort)
+
+	fmt.Printf("\nAccess Web UI as a non-root user (User: %v / Password: %v):\n", gokiCred.NonRootUserName, gokiCred.NonRootPassword)
 	fmt.Printf("  URL: https://%v:%v/\n", gokiWebUiIp, gokiWebUiPort)
 
-	fmt.Printf("\nAccess Web UI as a non-root user (User: %v / Password: %v):\n", gokiNonRootUserName, gokiNonRootUserPassword)
-	fmt.Printf("  URL: https://%v:%v/\n\n", gokiWebUiIp, gokiWebUiPort)
+	if createCmdFlags.lb {
+		showLbAccessCommand(gokiLbSqlPort, gokiLbWebUiPort)
+	} else {
+		fmt.Println()
+	}
 }
 
 func init() {
@@ -688,4 +1498,21 @@ func init() {
 	createCmd.Flags().IntVarP(&createCmdFlags.node, "node", "n", 3, "The number of cockroaches.")
 	createCmd.Flags().StringVar(&createCmdFlags.crdbVersion, "crdb-version", crdbVersion, "Version of CockroachDB (Tag of container image).")
 	createCmd.Flags().BoolVarP(&createCmdFlags.locality, "set-locality", "l", false, "Set --locality flag (region and zone value) to all nodes.")
+	createCmd.Flags().StringVar(&createCmdFlags.rootPassword, "root-password", gokiRootUserPassword, "Password of Root user. (env: "+gokiRootPasswordEnv+")")
+	createCmd.Flags().StringVar(&createCmdFlags.nonRootUserName, "non-root-user", gokiNonRootUserName, "Name of Non-root user. (env: "+gokiNonRootUserEnv+")")
+	createCmd.Flags().StringVar(&createCmdFlags.nonRootPassword, "non-root-password", gokiNonRootUserPassword, "Password of Non-root user. (env: "+gokiNonRootPasswordEnv+")")
+	createCmd.Flags().BoolVar(&createCmdFlags.generatePasswords, "generate-passwords", false, "Generate random passwords of Root user and Non-root user.")
+	createCmd.Flags().StringVar(&createCmdFlags.image, "image", "", "Container image of CockroachDB (e.g. registry.local/cockroach:custom). It overrides --crdb-version.")
+	createCmd.Flags().StringVar(&createCmdFlags.binary, "binary", "", "Path of the locally built cockroach binary (Linux) that is mounted into the container.")
+	createCmd.Flags().StringArrayVar(&createCmdFlags.nodeVersions, "node-version", []string{}, "Version of CockroachDB of the specific node (e.g. 3=v24.1.0). It can be specified multiple times.")
+	createCmd.Flags().StringArrayVar(&createCmdFlags.startFlags, "start-flag", []string{}, "Extra flag of cockroach start for all nodes (e.g. --cache=.25) or the specific node (e.g. 3=--attrs=ssd). It can be specified multiple times.")
+	createCmd.Flags().StringArrayVar(&createCmdFlags.env, "env", []string{}, "Environment variable for all nodes (e.g. COCKROACH_X=1) or the specific node (e.g. 3=COCKROACH_X=1). It can be specified multiple times.")
+	createCmd.Flags().StringArrayVar(&createCmdFlags.cpus, "cpus", []string{}, "Number of CPUs for all nodes (e.g. 1) or the specific node (e.g. 3=0.5).")
+	createCmd.Flags().StringArrayVar(&createCmdFlags.memory, "memory", []string{}, "Memory limit for all nodes (e.g. 2g) or the specific node (e.g. 3=512m).")
+	createCmd.Flags().IntVar(&createCmdFlags.stores, "stores", 1, "Number of stores per node.")
+	createCmd.Flags().StringVar(&createCmdFlags.memStore, "store", "", "Store spec of in-memory stores (e.g. type=mem,size=1GiB). The nodes do not have volumes.")
+	createCmd.Flags().BoolVar(&createCmdFlags.encryption, "encryption", false, "Enable encryption at rest. Goki generates the store key in the certs volume.")
+	createCmd.Flags().IntVar(&createCmdFlags.encryptionKeySize, "encryption-key-size", gokiEncryptionKeySize, "Size of AES key of the store key (128, 192, or 256).")
+	createCmd.Flags().BoolVar(&createCmdFlags.lb, "lb", false, "Launch the load balancer (HAProxy) in front of all nodes.")
+	createCmd.Flags().BoolVar(&createCmdFlags.keepOnFailure, "keep-on-failure", false, "Keep the created resources when goki create fails (for debugging).")
 }
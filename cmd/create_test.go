@@ -0,0 +1,165 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsAdjacentCrdbMajorVersion(t *testing.T) {
+	tests := []struct {
+		older string
+		newer string
+		want  bool
+	}{
+		{"v23.1.0", "v23.2.4", true},
+		{"v23.2.4", "v24.1.0", true},
+		{"v24.1.0", "v24.2.0", true},
+		{"v24.1.0", "v24.3.0", true},
+		{"v24.2.0", "v24.3.0", true},
+		{"v24.2.0", "v25.1.0", false},
+		{"v24.3.0", "v25.1.0", true},
+		{"v24.3.0", "v25.2.0", true},
+		{"v25.2.0", "v25.4.0", true},
+		{"v25.1.0", "v25.3.0", false},
+		{"v25.4.0", "v26.1.0", true},
+		{"v23.1.0", "v24.1.0", false},
+		{"v23.2.4", "v24.2.0", false},
+		{"v24.1.0", "v23.2.4", false},
+	}
+	for _, tt := range tests {
+		older, _ := crdbMajorVersion(tt.older)
+		newer, _ := crdbMajorVersion(tt.newer)
+		if got := isAdjacentCrdbMajorVersion(older, newer); got != tt.want {
+			t.Errorf("isAdjacentCrdbMajorVersion(%v, %v) = %v, want %v", tt.older, tt.newer, got, tt.want)
+		}
+	}
+}
+
+func TestParseGokiNodeOption(t *testing.T) {
+	defer func(node int) { createCmdFlags.node = node }(createCmdFlags.node)
+	createCmdFlags.node = 3
+
+	tests := []struct {
+		value   string
+		wantId  int
+		want    string
+		wantErr bool
+	}{
+		{value: "--cache=.25", wantId: 0, want: "--cache=.25"},
+		{value: "3=--attrs=ssd", wantId: 3, want: "--attrs=ssd"},
+		{value: "1=COCKROACH_SKIP_UPDATE_CHECK=true", wantId: 1, want: "COCKROACH_SKIP_UPDATE_CHECK=true"},
+		{value: "4=--attrs=ssd", wantErr: true},
+		{value: "0=--attrs=ssd", wantErr: true},
+	}
+	for _, tt := range tests {
+		id, got, err := parseGokiNodeOption("--start-flag", tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseGokiNodeOption(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (id != tt.wantId || got != tt.want) {
+			t.Errorf("parseGokiNodeOption(%q) = %d, %q, want %d, %q", tt.value, id, got, tt.wantId, tt.want)
+		}
+	}
+}
+
+func TestCheckGokiNodeOptions(t *testing.T) {
+	defer func(node int, startFlags []string) {
+		createCmdFlags.node, createCmdFlags.startFlags = node, startFlags
+	}(createCmdFlags.node, createCmdFlags.startFlags)
+	createCmdFlags.node = 3
+
+	tests := []struct {
+		startFlags []string
+		wantErr    bool
+	}{
+		{startFlags: []string{"--cache=.25", "3=--attrs=ssd"}},
+		{startFlags: []string{"--join=goki-1"}, wantErr: true},
+		{startFlags: []string{"2=--locality=region=us-east1"}, wantErr: true},
+		{startFlags: []string{"--insecure"}, wantErr: true},
+		{startFlags: []string{"cache=.25"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		createCmdFlags.startFlags = tt.startFlags
+		if err := checkGokiNodeOptions(); (err != nil) != tt.wantErr {
+			t.Errorf("checkGokiNodeOptions() with %q error = %v, wantErr %v", tt.startFlags, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGokiStoreFlags(t *testing.T) {
+	e := &gokiEncryptionMetadata{Key: "/cockroach/certs/encryption/new.key", OldKey: "plain"}
+	tests := []struct {
+		name     string
+		stores   int
+		memStore string
+		e        *gokiEncryptionMetadata
+		want     []string
+	}{
+		{name: "single store", stores: 1, want: []string{}},
+		{name: "multiple stores", stores: 2, want: []string{"--store=path=/cockroach/cockroach-data", "--store=path=/cockroach/cockroach-data-2"}},
+		{name: "in-memory stores", stores: 2, memStore: "type=mem,size=1GiB", want: []string{"--store=type=mem,size=1GiB", "--store=type=mem,size=1GiB"}},
+		{name: "encrypted single store", stores: 1, e: e, want: []string{
+			"--store=path=/cockroach/cockroach-data",
+			"--enterprise-encryption=path=/cockroach/cockroach-data,key=/cockroach/certs/encryption/new.key,old-key=plain",
+		}},
+		{name: "encrypted multiple stores", stores: 2, e: e, want: []string{
+			"--store=path=/cockroach/cockroach-data",
+			"--store=path=/cockroach/cockroach-data-2",
+			"--enterprise-encryption=path=/cockroach/cockroach-data,key=/cockroach/certs/encryption/new.key,old-key=plain",
+			"--enterprise-encryption=path=/cockroach/cockroach-data-2,key=/cockroach/certs/encryption/new.key,old-key=plain",
+		}},
+	}
+	for _, tt := range tests {
+		if got := gokiStoreFlags(tt.stores, tt.memStore, tt.e); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: gokiStoreFlags() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGokiStores(t *testing.T) {
+	defer func(stores int, memStore string, encryption bool, startFlags map[int][]string) {
+		createCmdFlags.stores, createCmdFlags.memStore, createCmdFlags.encryption, gokiStartFlags = stores, memStore, encryption, startFlags
+	}(createCmdFlags.stores, createCmdFlags.memStore, createCmdFlags.encryption, gokiStartFlags)
+
+	tests := []struct {
+		name       string
+		stores     int
+		memStore   string
+		encryption bool
+		startFlags map[int][]string
+		wantErr    bool
+	}{
+		{name: "single store with --store", stores: 1, startFlags: map[int][]string{0: {"--store=path=/cockroach/cockroach-data,attrs=ssd"}}},
+		{name: "multiple stores", stores: 3},
+		{name: "too many stores", stores: gokiMaxStores + 1, wantErr: true},
+		{name: "no store", stores: 0, wantErr: true},
+		{name: "--store= with --stores", stores: 2, startFlags: map[int][]string{0: {"--store=path=/data"}}, wantErr: true},
+		{name: "--store= of a node with --stores", stores: 2, startFlags: map[int][]string{3: {"--cache=.25", "--store=attrs=ssd"}}, wantErr: true},
+		{name: "-s= with in-memory stores", stores: 1, memStore: "type=mem,size=1GiB", startFlags: map[int][]string{0: {"-s=type=mem,size=2GiB"}}, wantErr: true},
+		{name: "--store= with encryption", stores: 1, encryption: true, startFlags: map[int][]string{0: {"--store=path=/data"}}, wantErr: true},
+		{name: "in-memory store", stores: 1, memStore: "type=mem,size=1GiB"},
+		{name: "in-memory store without size", stores: 1, memStore: "type=mem", wantErr: true},
+		{name: "on-disk store spec", stores: 1, memStore: "path=/data", wantErr: true},
+	}
+	for _, tt := range tests {
+		createCmdFlags.stores, createCmdFlags.memStore, createCmdFlags.encryption, gokiStartFlags = tt.stores, tt.memStore, tt.encryption, tt.startFlags
+		if err := checkGokiStores(); (err != nil) != tt.wantErr {
+			t.Errorf("%v: checkGokiStores() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
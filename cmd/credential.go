@@ -0,0 +1,89 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const (
+	// Environment variables that override the default credentials.
+	gokiRootPasswordEnv    string = "GOKI_ROOT_PASSWORD"
+	gokiNonRootUserEnv     string = "GOKI_NON_ROOT_USER"
+	gokiNonRootPasswordEnv string = "GOKI_NON_ROOT_PASSWORD"
+	// Length and characters of generated passwords.
+	gokiGeneratedPasswordLength int    = 16
+	gokiGeneratedPasswordChars  string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// Credentials of users that Goki sets in the cluster.
+type gokiCredential struct {
+	RootPassword    string `json:"root_password"`      // Password of Root user.
+	NonRootUserName string `json:"non_root_user_name"` // Name of Non-root user.
+	NonRootPassword string `json:"non_root_password"`  // Password of Non-root user.
+}
+
+// Credentials that are used in the current command.
+var gokiCred gokiCredential = defaultGokiCredential()
+
+func defaultGokiCredential() gokiCredential {
+	return gokiCredential{
+		RootPassword:    gokiRootUserPassword,
+		NonRootUserName: gokiNonRootUserName,
+		NonRootPassword: gokiNonRootUserPassword,
+	}
+}
+
+// loadGokiCredential() sets the credentials of the existing cluster to gokiCred.
+// If there is no metadata (e.g. the cluster was created by old Goki), the default credentials are used.
+func loadGokiCredential() error {
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	} else if m != nil {
+		gokiCred = m.Credential
+	}
+	return nil
+}
+
+// overrideGokiCredentialByEnv() overrides gokiCred by the environment variables.
+func overrideGokiCredentialByEnv() {
+	if v, ok := os.LookupEnv(gokiRootPasswordEnv); ok {
+		gokiCred.RootPassword = v
+	}
+	if v, ok := os.LookupEnv(gokiNonRootUserEnv); ok {
+		gokiCred.NonRootUserName = v
+	}
+	if v, ok := os.LookupEnv(gokiNonRootPasswordEnv); ok {
+		gokiCred.NonRootPassword = v
+	}
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, gokiGeneratedPasswordLength)
+	max := big.NewInt(int64(len(gokiGeneratedPasswordChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Generating random password failed.\n Error is: %v\n", err)
+			return "", err
+		}
+		b[i] = gokiGeneratedPasswordChars[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,651 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	gokiCardWidth int = 26 // Width of each node card in the dashboard.
+)
+
+// Flag value of dashboard command.
+var dashboardCmdFlags struct {
+	interval time.Duration // Interval of refreshing the dashboard.
+}
+
+// dashboardCmd represents the dashboard command
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Show the full-screen dashboard of the CockroachDB Local Cluster",
+	Long: `The "goki dashboard" command shows each node as a card laid out by region and zone.
+Each card shows the container state, liveness, QPS, p99 latency, and replication health of the node.
+* By default, it refreshes the dashboard every 2 seconds.
+    goki dashboard
+* You can specify the interval with --interval flag.
+    goki dashboard --interval 5s
+* Key bindings:
+    <-, ->, h, l, Tab : Select node
+    j                 : Jet (kill) the selected node
+    r                 : Revive (start) the selected node
+    f                 : Freeze (pause) the selected node
+    u                 : Unfreeze (unpause) the selected node
+    d                 : Drain the selected node
+    q, Ctrl-C         : Quit
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if dashboardCmdFlags.interval <= 0 {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify positive --interval.")
+			return errors.New("invalid argument. Please specify positive --interval")
+		}
+
+		if err := runGokiDashboard(dashboardCmdFlags.interval); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// Metrics of each node that the dashboard shows.
+type gokiNodeMetrics struct {
+	queryCount float64   // Value of sql.query.count.
+	latencyP99 float64   // Value of sql.service.latency-p99 (nanoseconds).
+	updatedAt  time.Time // Time when the node updated the metrics.
+	qps        float64   // Queries per second since the previous metrics.
+}
+
+// Data that the collector of the dashboard sends.
+type gokiDashboardData struct {
+	status  *gokiStatus
+	metrics map[string]gokiNodeMetrics // Key is container name.
+}
+
+func runGokiDashboard(interval time.Duration) error {
+	// Switch the terminal to non-canonical mode to read each key without Enter.
+	restore, err := setTerminalCbreak()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// Use the alternate screen, and hide the cursor.
+	screen := os.Stdout
+	fmt.Fprint(screen, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(screen, "\033[?25h\033[?1049l")
+
+	// The actions (e.g. gokiJet()) and the collector show INFO and ERROR messages. Capture them while the dashboard is shown.
+	out, restoreOutput, err := captureGokiOutput()
+	if err != nil {
+		return err
+	}
+	defer restoreOutput()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	// Stop the goroutines, and wait for them before restoring the output. So, they do not write to the closed file or the screen.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	keys := make(chan string)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readGokiKeys(keys, stop)
+	}()
+
+	dataCh := make(chan gokiDashboardData)
+	refresh := make(chan struct{}, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		collectGokiDashboardData(interval, dataCh, refresh, stop)
+	}()
+
+	// The actions run in the background, so that the dashboard keeps refreshing while they run (e.g. revive waits for the node).
+	// Only one action runs at a time.
+	results := make(chan string, 1)
+	running := false
+
+	var data gokiDashboardData
+	selected := 0
+	message := "Collecting the status..."
+	for {
+		drawGokiDashboard(screen, data, selected, message)
+
+		select {
+		case <-sig:
+			return nil
+		case data = <-dataCh:
+		case message = <-results:
+			running = false
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+		case key := <-keys:
+			n := 0
+			if data.status != nil {
+				n = len(data.status.Nodes)
+			}
+			switch key {
+			case "q":
+				if running {
+					drawGokiDashboard(screen, data, selected, "Waiting for the running action to finish...")
+				}
+				return nil
+			case "right", "l", "\t":
+				if n > 0 {
+					selected = (selected + 1) % n
+				}
+			case "left", "h":
+				if n > 0 {
+					selected = (selected + n - 1) % n
+				}
+			case "j", "r", "f", "u", "d":
+				if selected >= n {
+					continue
+				}
+				if running {
+					message = "Another action is running. Please wait for it to finish."
+					continue
+				}
+				node := data.status.Nodes[selected]
+				running = true
+				message = "Running the action to " + node.Name + "..."
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results <- doGokiDashboardAction(key, node, out)
+				}()
+			}
+		}
+	}
+}
+
+// doGokiDashboardAction() runs the action of the key to the node, and returns the message of the result.
+// If the action fails, the message includes the last line of the output that the action showed.
+func doGokiDashboardAction(key string, node gokiNodeStatus, out *os.File) string {
+	id, err := strconv.Atoi(strings.TrimPrefix(node.Name, gokiResourceName+"-"))
+	if err != nil {
+		return "Unknown node " + node.Name
+	}
+
+	offset := int64(0)
+	if info, err := out.Stat(); err == nil {
+		offset = info.Size()
+	}
+
+	var action string
+	switch key {
+	case "j":
+		action = "Jet"
+		err = gokiJet(id)
+	case "r":
+		action = "Revive"
+		err = gokiRevive(id)
+	case "f":
+		action = "Freeze"
+		err = gokiFreeze(id)
+	case "u":
+		action = "Unfreeze"
+		err = gokiUnfreeze(id)
+	case "d":
+		action = "Drain"
+		err = gokiDrain(id)
+	}
+
+	t := time.Now().Format("15:04:05")
+	if err != nil {
+		message := t + " " + action + " " + node.Name + " failed: " + err.Error()
+		if line := lastGokiOutputLine(out, offset); line != "" {
+			message += " (" + line + ")"
+		}
+		return colorize(gokiColorRed, message)
+	}
+	return colorize(gokiColorGreen, t+" "+action+" "+node.Name+" done")
+}
+
+// captureGokiOutput() redirects the standard output and error to a temporary file, so that they do not garble the full-screen view.
+// The returned function restores them, and removes the file.
+func captureGokiOutput() (*os.File, func(), error) {
+	f, err := os.CreateTemp("", gokiResourceName+"-dashboard-*.log")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating the temporary file failed.\n Error is: %v\n", err)
+		return nil, nil, err
+	}
+	// Re-open the file with O_APPEND, so that the concurrent writes of the actions and the collector do not overwrite each other.
+	out, err := os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0600)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		fmt.Fprintf(os.Stderr, "ERROR: Opening the temporary file failed.\n Error is: %v\n", err)
+		return nil, nil, err
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, out
+	return out, func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		out.Close()
+		os.Remove(out.Name())
+	}, nil
+}
+
+// lastGokiOutputLine() returns the last non-empty line that was written to the file after the offset.
+func lastGokiOutputLine(f *os.File, offset int64) string {
+	info, err := f.Stat()
+	if err != nil || info.Size() <= offset {
+		return ""
+	}
+	b := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(b, offset); err != nil && err != io.EOF {
+		return ""
+	}
+	lines := strings.FieldsFunc(string(b), func(r rune) bool { return r == '\n' })
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// gokiFreeze() pauses the container. The node stops responding without dying.
+func gokiFreeze(id int) error {
+	c := gokiCommand("docker", "pause", gokiResourceName+"-"+strconv.Itoa(id))
+	if output, err := c.CombinedOutput(); err != nil {
+		return errors.New("docker pause command failed: " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func gokiUnfreeze(id int) error {
+	c := gokiCommand("docker", "unpause", gokiResourceName+"-"+strconv.Itoa(id))
+	if output, err := c.CombinedOutput(); err != nil {
+		return errors.New("docker unpause command failed: " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// gokiDrain() drains the node. The node keeps running, but it moves leases and rejects new SQL connections.
+func gokiDrain(id int) error {
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
+		"./cockroach", "node", "drain", "--self",
+		"--certs-dir=/cockroach/certs/",
+		"--host="+gokiResourceName+"-"+strconv.Itoa(id)+":26257",
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		return errors.New("cockroach node drain command failed: " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// collectGokiDashboardData() sends the status and metrics every interval, or when refresh is requested.
+// It returns when stop is closed.
+func collectGokiDashboardData(interval time.Duration, dataCh chan<- gokiDashboardData, refresh <-chan struct{}, stop <-chan struct{}) {
+	prev := map[string]gokiNodeMetrics{}
+	for {
+		data := gokiDashboardData{metrics: map[string]gokiNodeMetrics{}}
+		if s, err := getGokiStatus(); err == nil {
+			data.status = s
+		}
+		if host := gokiLiveNode(data.status); host != "" {
+			if metrics, err := getGokiNodeMetrics(host); err == nil {
+				for name, m := range metrics {
+					// The nodes update the metrics every 10 seconds.
+					// So, calculate QPS from the time when the node updated them.
+					if p, ok := prev[name]; ok && m.updatedAt.After(p.updatedAt) {
+						m.qps = (m.queryCount - p.queryCount) / m.updatedAt.Sub(p.updatedAt).Seconds()
+					} else if ok {
+						m.qps = p.qps
+						m.queryCount = p.queryCount
+						m.updatedAt = p.updatedAt
+					}
+					data.metrics[name] = m
+				}
+				prev = data.metrics
+			}
+		}
+		select {
+		case dataCh <- data:
+		case <-stop:
+			return
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-refresh:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// gokiLiveNode() returns the name of the first running node, or empty string if there is no running node.
+func gokiLiveNode(s *gokiStatus) string {
+	if s == nil {
+		return ""
+	}
+	for _, n := range s.Nodes {
+		if n.Container == "running" {
+			return n.Name
+		}
+	}
+	return ""
+}
+
+// getGokiNodeMetrics() returns the metrics of all nodes (key is container name) from crdb_internal.kv_node_status.
+func getGokiNodeMetrics(host string) (map[string]gokiNodeMetrics, error) {
+	ctx, cancel := context.WithTimeout(gokiContext(), gokiStatusTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, "docker", "exec", gokiResourceName+"-client",
+		"./cockroach", "sql",
+		"--format=csv",
+		"--certs-dir=/cockroach/certs/",
+		"--host="+host+":26257",
+		"-e", "SELECT address, updated_at, "+
+			"COALESCE(metrics->>'sql.query.count', '0') AS query_count, "+
+			"COALESCE(metrics->>'sql.service.latency-p99', '0') AS latency_p99 "+
+			"FROM crdb_internal.kv_node_status",
+	)
+
+	output, err := c.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(output)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]gokiNodeMetrics{}
+	for i, record := range records {
+		if i == 0 || len(record) < 4 {
+			continue
+		}
+		m := gokiNodeMetrics{}
+		m.updatedAt, _ = parseCrdbTimestamp(record[1])
+		m.queryCount, _ = strconv.ParseFloat(record[2], 64)
+		m.latencyP99, _ = strconv.ParseFloat(record[3], 64)
+		// The address is "<container name>:26257".
+		metrics[strings.Split(record[0], ":")[0]] = m
+	}
+	return metrics, nil
+}
+
+func drawGokiDashboard(screen *os.File, data gokiDashboardData, selected int, message string) {
+	var b bytes.Buffer
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "goki dashboard    %v\n", time.Now().Format("15:04:05"))
+	fmt.Fprintln(&b, "[<-/->] select  [j] jet  [r] revive  [f] freeze  [u] unfreeze  [d] drain  [q] quit")
+	fmt.Fprintln(&b)
+
+	if data.status == nil {
+		fmt.Fprintln(&b, "There is no containers of Goki.")
+	} else {
+		writeGokiRegions(&b, data, selected, terminalWidth())
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, message)
+	screen.Write(b.Bytes())
+}
+
+// writeGokiRegions() writes the node cards grouped by region. Each row of a region has its zones in order.
+func writeGokiRegions(b *bytes.Buffer, data gokiDashboardData, selected int, width int) {
+	regions := map[string][]int{}
+	for i, n := range data.status.Nodes {
+		region, _ := splitLocality(n.Locality)
+		regions[region] = append(regions[region], i)
+	}
+
+	names := []string{}
+	for region := range regions {
+		names = append(names, region)
+	}
+	sort.Strings(names)
+
+	perRow := width / (gokiCardWidth + 1)
+	if perRow < 1 {
+		perRow = 1
+	}
+
+	for _, region := range names {
+		nodes := regions[region]
+		sort.SliceStable(nodes, func(i, j int) bool {
+			_, zi := splitLocality(data.status.Nodes[nodes[i]].Locality)
+			_, zj := splitLocality(data.status.Nodes[nodes[j]].Locality)
+			return zi < zj
+		})
+
+		if region == "" || region == "region-0" {
+			fmt.Fprintln(b, "[Cluster]")
+		} else {
+			fmt.Fprintln(b, "[Region "+region+"]")
+		}
+
+		for start := 0; start < len(nodes); start += perRow {
+			end := start + perRow
+			if end > len(nodes) {
+				end = len(nodes)
+			}
+			var cards [][]string
+			for _, i := range nodes[start:end] {
+				n := data.status.Nodes[i]
+				cards = append(cards, gokiCard(n, data.metrics[n.Name], i == selected))
+			}
+			for line := range cards[0] {
+				for _, card := range cards {
+					b.WriteString(card[line] + " ")
+				}
+				b.WriteString("\n")
+			}
+		}
+		fmt.Fprintln(b)
+	}
+}
+
+// gokiCard() returns the lines of the card of the node. The selected card has the double border.
+func gokiCard(n gokiNodeStatus, m gokiNodeMetrics, selected bool) []string {
+	horizontal, vertical := "-", "|"
+	if selected {
+		horizontal, vertical = "=", "#"
+	}
+	inner := gokiCardWidth - 2
+
+	title := "[" + n.Name + "]"
+	_, zone := splitLocality(n.Locality)
+	if zone != "" && zone != "zone-0" {
+		title += "[" + zone + "]"
+	}
+
+	state, color := n.Container, gokiColorRed
+	switch {
+	case n.Container == "running" && n.IsLive && !n.IsDraining:
+		state, color = "live", gokiColorGreen
+	case n.Container == "running" && n.IsDraining:
+		state, color = "draining", gokiColorYellow
+	case n.Container == "running" && n.NodeId != 0:
+		state, color = "suspect", gokiColorYellow
+	case n.Container == "paused":
+		state, color = "frozen", gokiColorYellow
+	}
+
+	replication := "ranges -"
+	if n.NodeId != 0 {
+		replication = fmt.Sprintf("ranges %d under %d", n.Ranges, n.RangesUnderReplicated)
+		if n.RangesUnavailable > 0 {
+			replication += fmt.Sprintf(" unavail %d", n.RangesUnavailable)
+		}
+	}
+
+	traffic := "qps -  p99 -"
+	if !m.updatedAt.IsZero() {
+		traffic = fmt.Sprintf("qps %.1f  p99 %v", m.qps, time.Duration(m.latencyP99).Round(time.Microsecond))
+	}
+
+	pad := func(s string) string {
+		if len(s) > inner-2 {
+			s = s[:inner-2]
+		}
+		return " " + s + strings.Repeat(" ", inner-2-len(s)) + " "
+	}
+
+	return []string{
+		"+" + horizontal + title + strings.Repeat(horizontal, max0(inner-1-len(title))) + "+",
+		vertical + color + pad(state) + gokiColorReset + vertical,
+		vertical + pad(traffic) + vertical,
+		vertical + pad(replication) + vertical,
+		"+" + strings.Repeat(horizontal, inner) + "+",
+	}
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// splitLocality() returns the region and zone of the locality (e.g. "region=region-1,zone=zone-1").
+func splitLocality(locality string) (string, string) {
+	var region, zone string
+	for _, tier := range strings.Split(locality, ",") {
+		kv := strings.SplitN(tier, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "region":
+			region = kv[1]
+		case "zone":
+			zone = kv[1]
+		}
+	}
+	return region, zone
+}
+
+// readGokiKeys() sends each key from stdin. Arrow keys are sent as "left", "right", "up", and "down".
+// It returns when stop is closed. setTerminalCbreak() makes the read time out, so that it checks stop even if no key is pressed.
+func readGokiKeys(keys chan<- string, stop <-chan struct{}) {
+	buf := make([]byte, 8)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		n, err := os.Stdin.Read(buf)
+		if err == io.EOF {
+			// The read timed out without any key.
+			continue
+		} else if err != nil {
+			return
+		}
+		s := string(buf[:n])
+		switch s {
+		case "\033[C":
+			s = "right"
+		case "\033[D":
+			s = "left"
+		case "\033[A":
+			s = "up"
+		case "\033[B":
+			s = "down"
+		}
+		select {
+		case keys <- s:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// setTerminalCbreak() disables the canonical mode and echo of the terminal by stty command.
+// Each read of stdin returns after 0.1 seconds, even if no key is pressed.
+// It returns the function that restores the previous settings.
+func setTerminalCbreak() (func(), error) {
+	c := exec.Command("stty", "-g")
+	c.Stdin = os.Stdin
+	saved, err := c.Output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: The dashboard needs a terminal. Getting terminal settings by stty command failed.")
+		return nil, err
+	}
+
+	c = exec.Command("stty", "-icanon", "-echo", "min", "0", "time", "1")
+	c.Stdin = os.Stdin
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: stty command failed: %v\n", string(output))
+		return nil, err
+	}
+
+	return func() {
+		c := exec.Command("stty", strings.TrimSpace(string(saved)))
+		c.Stdin = os.Stdin
+		c.Run()
+	}, nil
+}
+
+// terminalWidth() returns the number of columns of the terminal. It returns 80, if stty command failed.
+func terminalWidth() int {
+	c := exec.Command("stty", "size")
+	c.Stdin = os.Stdin
+	output, err := c.Output()
+	if err != nil {
+		return 80
+	}
+	f := strings.Fields(string(output))
+	if len(f) != 2 {
+		return 80
+	}
+	if w, err := strconv.Atoi(f[1]); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+func init() {
+	rootCmd.AddCommand(dashboardCmd)
+	// Flags of goki dashboard.
+	dashboardCmd.Flags().DurationVar(&dashboardCmdFlags.interval, "interval", 2*time.Second, "Interval of refreshing the dashboard.")
+}
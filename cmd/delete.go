@@ -17,7 +17,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -52,6 +51,7 @@ var deleteCmd = &cobra.Command{
 		fmt.Printf("\n*** Deleting CockroachDB Local Cluster done ***")
 		if deleteCmdFlags.volume {
 			fmt.Printf("\nINFO: All docker resources (include docker volume) are deleted.\n")
+			fmt.Println("INFO: The backups in docker volume " + gokiResourceName + "-backup are not deleted.")
 		} else {
 			fmt.Printf("\nINFO: You can re-create the cluster with DB Data of deleted cluster (you can re-use deleted cluster's Data).")
 			fmt.Printf("\n      If you want to re-use old Data, re-run \"goki create\" command.")
@@ -75,10 +75,14 @@ func deleteGokiCluster() error {
 	}
 
 	// Delete docker volume, if --volume (-v) specified.
+	// The metadata is also deleted, because the credentials in it are not used anymore.
 	if deleteCmdFlags.volume {
 		if err := deleteGokiVolume(); err != nil {
 			return err
 		}
+		if err := deleteGokiMetadata(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -91,7 +95,7 @@ func deleteGokiContainers() error {
 	var deadGokiList []string = []string{}
 
 	// Get list of running container that related to Goki.
-	c := exec.Command("docker", "ps", "-f", "label="+gokiResourceLabel, "--format", "{{.Names}}")
+	c := gokiCommand("docker", "ps", "-f", "label="+gokiResourceLabel, "--format", "{{.Names}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
@@ -104,7 +108,7 @@ func deleteGokiContainers() error {
 	// So, use "docker kill" instead of "docker stop".
 	if len(liveGokiList) != 0 {
 		for _, container := range liveGokiList {
-			c := exec.Command("docker", "kill", container)
+			c := gokiCommand("docker", "kill", container)
 			if output, err := c.CombinedOutput(); err != nil {
 				fmt.Fprintf(os.Stderr, "docker kill command failed: %v\n", string(output))
 				return err
@@ -113,7 +117,7 @@ func deleteGokiContainers() error {
 	}
 
 	// Get list of stopped containers to remove them.
-	c = exec.Command("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}")
+	c = gokiCommand("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
@@ -125,7 +129,7 @@ func deleteGokiContainers() error {
 	// Remove all containers that related to Goki.
 	if len(deadGokiList) != 0 {
 		for _, container := range deadGokiList {
-			c := exec.Command("docker", "rm", container)
+			c := gokiCommand("docker", "rm", container)
 			if output, err := c.CombinedOutput(); err != nil {
 				fmt.Fprintf(os.Stderr, "docker rm command failed: %v\n", string(output))
 				return err
@@ -150,7 +154,7 @@ func deleteGokiNetwork() error {
 	var gokiNetworkName string
 
 	// Get goki network name.
-	c := exec.Command("docker", "network", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
+	c := gokiCommand("docker", "network", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker network ls command failed: %v\n", string(output))
@@ -162,7 +166,7 @@ func deleteGokiNetwork() error {
 
 	// Remove docker network that related to Goki.
 	if gokiNetworkName != "" {
-		c := exec.Command("docker", "network", "rm", gokiNetworkName)
+		c := gokiCommand("docker", "network", "rm", gokiNetworkName)
 		if output, err := c.CombinedOutput(); err != nil {
 			fmt.Fprintf(os.Stderr, "docker network rm command failed: %v\n", string(output))
 			return err
@@ -184,7 +188,7 @@ func deleteGokiVolume() error {
 	var gokiVolumeList []string = []string{}
 
 	// Get list of goki volume.
-	c := exec.Command("docker", "volume", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
+	c := gokiCommand("docker", "volume", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
 
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker volume ls command failed: %v\n", string(output))
@@ -196,7 +200,7 @@ func deleteGokiVolume() error {
 	// Remove all volumes that related to Goki.
 	if len(gokiVolumeList) != 0 {
 		for _, volume := range gokiVolumeList {
-			c := exec.Command("docker", "volume", "rm", volume)
+			c := gokiCommand("docker", "volume", "rm", volume)
 			if output, err := c.CombinedOutput(); err != nil {
 				fmt.Fprintf(os.Stderr, "docker volume rm command failed: %v\n", string(output))
 				return err
@@ -0,0 +1,352 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to disk fault injection.
+	gokiDiskFillFile string = "goki-disk-fill" // Name of the file that fills the data volume.
+	gokiDiskMount    string = "/data"          // Directory that the data volume is mounted to in the temporary container.
+)
+
+// Block I/O throttling of the data volume of a node.
+type gokiDiskThrottle struct {
+	Device    string `json:"device"`               // Block device of the data volume in the Docker host (e.g. /dev/sda).
+	ReadBps   string `json:"read_bps,omitempty"`   // Limit of read rate (e.g. 1mb).
+	WriteBps  string `json:"write_bps,omitempty"`  // Limit of write rate (e.g. 1mb).
+	ReadIops  int    `json:"read_iops,omitempty"`  // Limit of read IO per second.
+	WriteIops int    `json:"write_iops,omitempty"` // Limit of write IO per second.
+}
+
+// Flag value of disk fill command.
+var diskFillCmdFlags struct {
+	gokiId int    // Number of node (container).
+	leave  string // Size of the free space that is left.
+	yes    bool   // If true, fill the filesystem of the Docker host without the confirmation.
+}
+
+// Flag value of disk slow command.
+var diskSlowCmdFlags struct {
+	gokiId    int    // Number of node (container).
+	readBps   string // Limit of read rate.
+	writeBps  string // Limit of write rate.
+	readIops  int    // Limit of read IO per second.
+	writeIops int    // Limit of write IO per second.
+}
+
+// Flag value of disk restore command.
+var diskRestoreCmdFlags struct {
+	gokiId int  // Number of node (container).
+	all    bool // If true, restore all nodes.
+}
+
+// diskCmd represents the disk command
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Inject disk faults into nodes",
+	Long: `The "goki disk" command injects disk faults (full disk and slow disk) into the data volume of nodes,
+so that you can observe the disk-stall detection and the ballast behavior of CockroachDB.
+You can remove the faults by "goki disk restore" command.`,
+}
+
+// diskFillCmd represents the disk fill command
+var diskFillCmd = &cobra.Command{
+	Use:   "fill",
+	Short: "Fill the data volume of a node",
+	Long: `The "goki disk fill" command creates a file in the data volume of the node, so that only the specified free space is left.
+Docker volumes share the filesystem of the Docker host (or VM). So, the other nodes, the other containers, and the host also see the small free space.
+Because of it, goki disk fill requires --yes flag to confirm it.
+* By default, it fills the data volume of Node 1 and leaves 100MiB.
+    goki disk fill --yes
+* You can specify the Node ID with -g (--goki) flag, and the free space with --leave flag.
+    goki disk fill -g 3 --leave 1GiB --yes
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if !diskFillCmdFlags.yes {
+			fmt.Fprintln(os.Stderr, "ERROR: goki disk fill fills the filesystem of the Docker host (or VM) that all Docker volumes share.")
+			fmt.Fprintln(os.Stderr, " The other nodes, the other containers, and the host also see the small free space until \"goki disk restore\" command.")
+			fmt.Fprintln(os.Stderr, "HINT: If it is OK, please specify --yes flag.")
+			return errors.New("goki disk fill requires --yes flag")
+		}
+
+		leave, err := parseGokiSize(diskFillCmdFlags.leave)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The value of --leave flag %v is not a size (e.g. 100MiB).\n", diskFillCmdFlags.leave)
+			return err
+		}
+
+		if _, err := loadGokiDiskNode(diskFillCmdFlags.gokiId); err != nil {
+			return err
+		}
+
+		if err := fillGokiDisk(diskFillCmdFlags.gokiId, leave); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// diskSlowCmd represents the disk slow command
+var diskSlowCmd = &cobra.Command{
+	Use:   "slow",
+	Short: "Throttle block I/O of the data volume of a node",
+	Long: `The "goki disk slow" command throttles block I/O of the block device that the data volume of the node is on.
+Docker cannot update the block I/O throttling of the running container. So, Goki re-creates the node with the throttling.
+It is available only if the data volume is on a block device (e.g. not on overlay or tmpfs) and the cgroup supports it.
+* By default, it throttles Node 1. You can specify the Node ID with -g (--goki) flag.
+    goki disk slow -g 3 --write-bps 1mb
+    goki disk slow -g 3 --write-iops 10 --read-iops 10
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if diskSlowCmdFlags.readBps == "" && diskSlowCmdFlags.writeBps == "" && diskSlowCmdFlags.readIops <= 0 && diskSlowCmdFlags.writeIops <= 0 {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify --read-bps, --write-bps, --read-iops, or --write-iops flag.")
+			return errors.New("invalid argument. Please specify the limit of block I/O")
+		}
+		for _, bps := range []string{diskSlowCmdFlags.readBps, diskSlowCmdFlags.writeBps} {
+			if bps != "" && !gokiMemoryRegexp.MatchString(bps) {
+				fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The rate %v is not a size per second (e.g. 1mb).\n", bps)
+				return errors.New("invalid argument. The rate is invalid")
+			}
+		}
+
+		m, err := loadGokiDiskNode(diskSlowCmdFlags.gokiId)
+		if err != nil {
+			return err
+		}
+
+		device, err := getGokiDiskDevice(diskSlowCmdFlags.gokiId)
+		if err != nil {
+			return err
+		}
+
+		n := &m.Nodes[diskSlowCmdFlags.gokiId-1]
+		prev := *n
+		n.DiskThrottle = &gokiDiskThrottle{
+			Device:    device,
+			ReadBps:   diskSlowCmdFlags.readBps,
+			WriteBps:  diskSlowCmdFlags.writeBps,
+			ReadIops:  diskSlowCmdFlags.readIops,
+			WriteIops: diskSlowCmdFlags.writeIops,
+		}
+		if err := recreateGokiNode(m, prev, *n); err != nil {
+			return err
+		}
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+		fmt.Println("INFO: Throttled block I/O of " + n.Name + " (" + device + ").")
+
+		return nil
+	},
+}
+
+// diskRestoreCmd represents the disk restore command
+var diskRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Remove disk faults from nodes",
+	Long: `The "goki disk restore" command removes the file that "goki disk fill" created, and the throttling that "goki disk slow" set.
+* By default, it restores Node 1. You can specify the Node ID with -g (--goki) flag, or all nodes with --all flag.
+    goki disk restore -g 3
+    goki disk restore --all
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if diskRestoreCmdFlags.all && cmd.Flags().Changed("goki") {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The -g (--goki) flag cannot be used with --all flag.")
+			return errors.New("invalid argument. The -g (--goki) flag cannot be used with --all flag")
+		}
+
+		m, err := loadGokiDiskNode(diskRestoreCmdFlags.gokiId)
+		if err != nil {
+			return err
+		}
+
+		for i := range m.Nodes {
+			if !diskRestoreCmdFlags.all && m.Nodes[i].Id != diskRestoreCmdFlags.gokiId {
+				continue
+			}
+			// Re-create the next throttled node after the ranges are fully replicated again, as in the rolling restart.
+			if diskRestoreCmdFlags.all && m.Nodes[i].DiskThrottle != nil {
+				if err := waitGokiReplication(m); err != nil {
+					return err
+				}
+			}
+			if err := restoreGokiDisk(m, &m.Nodes[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+// loadGokiDiskNode() returns the metadata of the cluster, and checks the node has the data volume.
+func loadGokiDiskNode(id int) (*gokiMetadata, error) {
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		fmt.Fprintln(os.Stderr, "ERROR: There is no metadata of the Goki Cluster.")
+		fmt.Fprintln(os.Stderr, "HINT: Please create the cluster by \"goki create\" command.")
+		return nil, errors.New("there is no metadata of the Goki Cluster")
+	} else if m.MemStore != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: The Goki Cluster uses in-memory stores. The nodes do not have data volumes.")
+		return nil, errors.New("the Goki Cluster uses in-memory stores")
+	}
+	if err := checkGokiNodeId(m, id); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// runGokiDiskScript() runs the shell script in a temporary container that mounts the data volume (the first store) of the node.
+// So, it works even if the node is dead.
+// If Goki is interrupted or times out, killing docker does not stop the container. So, it removes the container by its name.
+func runGokiDiskScript(id int, script string) (string, error) {
+	helper := gokiResourceName + "-" + strconv.Itoa(id) + "-disk-" + strconv.Itoa(os.Getpid())
+	defer runGokiCleanup(func() {
+		gokiCommand("docker", "rm", "-f", helper).Run()
+	})
+
+	c := gokiCommand("docker", "run", "--rm", "--init",
+		"--name="+helper,
+		"--mount=type=volume,src="+gokiResourceName+"-volume-"+strconv.Itoa(id)+",dst="+gokiDiskMount,
+		"--label="+gokiHelperLabel,
+		"--entrypoint=sh",
+		gokiImage(),
+		"-c", script,
+	)
+	output, err := c.CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+func fillGokiDisk(id int, leave int64) error {
+	name := gokiResourceName + "-" + strconv.Itoa(id)
+	fmt.Println("INFO: Filling the data volume of " + name + " start.")
+
+	// Re-create the file, so that the free space is the specified size even if the node has written data after the last fill.
+	// If fallocate is not available, write zeros until the size (or the disk is full).
+	f := gokiDiskMount + "/" + gokiDiskFillFile
+	script := "rm -f " + f + "; " +
+		"avail=$(df -B1 --output=avail " + gokiDiskMount + " | tail -n 1); " +
+		"size=$((avail - " + strconv.FormatInt(leave, 10) + ")); " +
+		"if [ $size -gt 0 ]; then fallocate -l $size " + f + " 2>/dev/null || dd if=/dev/zero of=" + f + " bs=1048576 count=$((size / 1048576)) 2>/dev/null; fi; " +
+		"df -B1 --output=avail " + gokiDiskMount + " | tail -n 1"
+	output, err := runGokiDiskScript(id, script)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Filling the data volume of %v failed.\n Error is: %v\n", name, output)
+		return err
+	}
+
+	avail, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting the free space of the data volume of %v failed.\n Error is: %v\n", name, output)
+		return err
+	}
+	fmt.Println("INFO: Filling the data volume of " + name + " done. The free space is " + formatGokiMemory(avail) + ".")
+	return nil
+}
+
+// getGokiDiskDevice() returns the block device (the whole disk) that the data volume of the node is on.
+// It finds the device number of the mount in /proc/self/mountinfo, and its name in /sys/dev/block of the Docker host.
+func getGokiDiskDevice(id int) (string, error) {
+	script := "while read -r mid pid dev root mnt rest; do [ \"$mnt\" = " + gokiDiskMount + " ] && d=$dev; done < /proc/self/mountinfo; " +
+		"[ -n \"$d\" ] && [ -e /sys/dev/block/$d ] || { echo \"device $d is not a block device\"; exit 1; }; " +
+		"p=$(readlink -f /sys/dev/block/$d); " +
+		"[ -f $p/partition ] && p=$(dirname $p); " +
+		"basename $p"
+	output, err := runGokiDiskScript(id, script)
+	if err != nil || output == "" {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting the block device of the data volume failed.\n Error is: %v\n", output)
+		fmt.Fprintln(os.Stderr, "HINT: The block I/O throttling is available only if the data volume of Docker is on a block device.")
+		return "", errors.New("getting the block device of the data volume failed")
+	}
+	return "/dev/" + output, nil
+}
+
+// gokiDiskThrottleArgs() returns the options of "docker run" that throttle block I/O.
+func gokiDiskThrottleArgs(t *gokiDiskThrottle) []string {
+	args := []string{}
+	if t == nil {
+		return args
+	}
+	if t.ReadBps != "" {
+		args = append(args, "--device-read-bps="+t.Device+":"+t.ReadBps)
+	}
+	if t.WriteBps != "" {
+		args = append(args, "--device-write-bps="+t.Device+":"+t.WriteBps)
+	}
+	if t.ReadIops > 0 {
+		args = append(args, "--device-read-iops="+t.Device+":"+strconv.Itoa(t.ReadIops))
+	}
+	if t.WriteIops > 0 {
+		args = append(args, "--device-write-iops="+t.Device+":"+strconv.Itoa(t.WriteIops))
+	}
+	return args
+}
+
+func restoreGokiDisk(m *gokiMetadata, n *gokiNodeMetadata) error {
+	if output, err := runGokiDiskScript(n.Id, "rm -f "+gokiDiskMount+"/"+gokiDiskFillFile); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Removing the file in the data volume of %v failed.\n Error is: %v\n", n.Name, output)
+		return err
+	}
+	fmt.Println("INFO: Removed the file that fills the data volume of " + n.Name + ".")
+
+	if n.DiskThrottle == nil {
+		return nil
+	}
+	prev := *n
+	n.DiskThrottle = nil
+	if err := recreateGokiNode(m, prev, *n); err != nil {
+		return err
+	}
+	if err := saveGokiMetadata(m); err != nil {
+		return err
+	}
+	fmt.Println("INFO: Removed the block I/O throttling of " + n.Name + ".")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(diskCmd)
+	diskCmd.AddCommand(diskFillCmd)
+	diskCmd.AddCommand(diskSlowCmd)
+	diskCmd.AddCommand(diskRestoreCmd)
+	// Flags of goki disk fill.
+	diskFillCmd.Flags().IntVarP(&diskFillCmdFlags.gokiId, "goki", "g", 1, "The node ID of container that goki disk fill command will fill.")
+	diskFillCmd.Flags().StringVar(&diskFillCmdFlags.leave, "leave", "100MiB", "Size of the free space that is left (e.g. 100MiB).")
+	diskFillCmd.Flags().BoolVar(&diskFillCmdFlags.yes, "yes", false, "Confirm that goki disk fill fills the filesystem of the Docker host that all Docker volumes share.")
+	// Flags of goki disk slow.
+	diskSlowCmd.Flags().IntVarP(&diskSlowCmdFlags.gokiId, "goki", "g", 1, "The node ID of container that goki disk slow command will throttle.")
+	diskSlowCmd.Flags().StringVar(&diskSlowCmdFlags.readBps, "read-bps", "", "Limit of read rate (e.g. 1mb).")
+	diskSlowCmd.Flags().StringVar(&diskSlowCmdFlags.writeBps, "write-bps", "", "Limit of write rate (e.g. 1mb).")
+	diskSlowCmd.Flags().IntVar(&diskSlowCmdFlags.readIops, "read-iops", 0, "Limit of read IO per second.")
+	diskSlowCmd.Flags().IntVar(&diskSlowCmdFlags.writeIops, "write-iops", 0, "Limit of write IO per second.")
+	// Flags of goki disk restore.
+	diskRestoreCmd.Flags().IntVarP(&diskRestoreCmdFlags.gokiId, "goki", "g", 1, "The node ID of container that goki disk restore command will restore.")
+	diskRestoreCmd.Flags().BoolVar(&diskRestoreCmdFlags.all, "all", false, "Restore all nodes.")
+}
@@ -0,0 +1,371 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to encryption at rest.
+	gokiEncryptionDir     string = "/cockroach/certs/encryption" // Directory of the store keys in the certs volume (goki-volume-client).
+	gokiEncryptionPlain   string = "plain"                       // Value of old-key when the store was not encrypted.
+	gokiEncryptionKeySize int    = 128                           // Default size of AES key.
+	// Related to the rolling restart.
+	gokiReplicationInterval time.Duration = 5 * time.Second  // Interval of checking the under-replicated ranges.
+	gokiReplicationTimeout  time.Duration = 10 * time.Minute // Waiting for the under-replicated ranges after re-creating a node.
+)
+
+// Algorithms of encryption at rest that the metric "rocksdb.encryption.algorithm" shows.
+var gokiEncryptionAlgorithms = []string{"plaintext", "AES128_CTR", "AES192_CTR", "AES256_CTR", "AES128_CTR_V2", "AES192_CTR_V2", "AES256_CTR_V2"}
+
+// Store keys of encryption at rest.
+type gokiEncryptionMetadata struct {
+	KeySize   int       `json:"key_size"`          // Size of AES key (128, 192, or 256).
+	Key       string    `json:"key"`               // Path of the active store key in the node.
+	OldKey    string    `json:"old_key"`           // Path of the previous store key in the node, or "plain".
+	RotatedAt time.Time `json:"rotated_at"`        // Time when Goki generated the active store key.
+	Pending   []int     `json:"pending,omitempty"` // IDs of the nodes that are not restarted with the active store key yet.
+}
+
+// Store keys that are used in the current command.
+var gokiEncryption *gokiEncryptionMetadata
+
+// Flag value of encryption rotate command.
+var encryptionRotateCmdFlags struct {
+	keySize int // Size of AES key of the new store key.
+}
+
+// encryptionCmd represents the encryption command
+var encryptionCmd = &cobra.Command{
+	Use:   "encryption",
+	Short: "Manage encryption at rest of the cluster",
+	Long: `The "goki encryption" command manages the store keys of encryption at rest.
+You can create the cluster with encryption at rest by "goki create --encryption" command.
+Goki stores the store keys in the certs volume (` + gokiResourceName + `-volume-client).`,
+}
+
+// encryptionRotateCmd represents the encryption rotate command
+var encryptionRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the store key",
+	Long: `The "goki encryption rotate" command generates a new store key, and restarts nodes one at a time
+with the new key and the previous key (old-key). Before restarting each node, it waits until there is no under-replicated range.
+After that, it shows the encryption status of each store.
+* By default, the new key has the same size as the current key.
+    goki encryption rotate
+* You can change the size of AES key with --key-size flag.
+    goki encryption rotate --key-size 256
+* If the rotation fails in the middle, you can run it again. It restarts the rest of the nodes with the same new key.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		m, err := loadGokiEncryptedCluster()
+		if err != nil {
+			return err
+		}
+
+		keySize := m.Encryption.KeySize
+		if cmd.Flags().Changed("key-size") {
+			keySize = encryptionRotateCmdFlags.keySize
+		}
+		if err := checkGokiEncryptionKeySize(keySize); err != nil {
+			return err
+		}
+
+		if err := rotateGokiEncryptionKey(m, keySize); err != nil {
+			return err
+		}
+
+		if err := showGokiEncryptionStatus(m); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// encryptionStatusCmd represents the encryption status command
+var encryptionStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the encryption status of each store",
+	Long:  `The "goki encryption status" command shows the active store key and whether each store is encrypted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		m, err := loadGokiEncryptedCluster()
+		if err != nil {
+			return err
+		}
+
+		if err := showGokiEncryptionStatus(m); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// loadGokiEncryptedCluster() returns the metadata of the cluster, if it uses encryption at rest.
+func loadGokiEncryptedCluster() (*gokiMetadata, error) {
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return nil, err
+	} else if m == nil || m.Encryption == nil {
+		fmt.Fprintln(os.Stderr, "ERROR: The Goki Cluster does not use encryption at rest.")
+		fmt.Fprintln(os.Stderr, "HINT: Please create the cluster by \"goki create --encryption\" command.")
+		return nil, errors.New("the Goki Cluster does not use encryption at rest")
+	}
+	return m, nil
+}
+
+func checkGokiEncryptionKeySize(size int) error {
+	if size != 128 && size != 192 && size != 256 {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the size of AES key 128, 192, or 256.")
+		return errors.New("invalid argument. The size of AES key is invalid")
+	}
+	return nil
+}
+
+// setGokiEncryption() sets the store keys that "goki create" uses to gokiEncryption.
+// If the existing data is encrypted, it re-uses the store keys in the metadata.
+func setGokiEncryption() error {
+	gokiEncryption = nil
+
+	if gokiVolumeAlreadyExist {
+		m, err := loadGokiMetadata()
+		if err != nil {
+			return err
+		} else if m != nil && m.Encryption != nil {
+			if !createCmdFlags.encryption {
+				fmt.Println("INFO: The existing data is encrypted. Use the existing store key.")
+			}
+			gokiEncryption = m.Encryption
+			return nil
+		}
+	}
+
+	if !createCmdFlags.encryption {
+		return nil
+	}
+	if createCmdFlags.memStore != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --encryption flag cannot be used with in-memory stores.")
+		return errors.New("invalid argument. The --encryption flag cannot be used with in-memory stores")
+	}
+	if err := checkGokiEncryptionKeySize(createCmdFlags.encryptionKeySize); err != nil {
+		return err
+	}
+
+	// If the existing data is not encrypted, CockroachDB encrypts it with the new key gradually (old-key=plain).
+	gokiEncryption = newGokiEncryptionKey(createCmdFlags.encryptionKeySize, gokiEncryptionPlain)
+	return nil
+}
+
+// newGokiEncryptionKey() returns the path of a new store key. The key file is created by createGokiEncryptionKey().
+func newGokiEncryptionKey(size int, oldKey string) *gokiEncryptionMetadata {
+	now := time.Now()
+	return &gokiEncryptionMetadata{
+		KeySize:   size,
+		Key:       path.Join(gokiEncryptionDir, "aes-"+strconv.Itoa(size)+"-"+now.UTC().Format("20060102T150405")+".key"),
+		OldKey:    oldKey,
+		RotatedAt: now,
+	}
+}
+
+// createGokiEncryptionKey() generates the store key by "cockroach gen encryption-key" in the client container, if it does not exist.
+func createGokiEncryptionKey(e *gokiEncryptionMetadata) error {
+	if e == nil {
+		return nil
+	}
+
+	fmt.Println("INFO: Creating store key " + e.Key + " start.")
+	c := gokiCommand("docker", "exec", gokiResourceName+"-client",
+		"sh", "-c", "mkdir -p "+gokiEncryptionDir+" && (test -f "+e.Key+" || ./cockroach gen encryption-key -s "+strconv.Itoa(e.KeySize)+" "+e.Key+")",
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: cockroach gen encryption-key command failed.\n Error is: %v\n", string(output))
+		return err
+	}
+	fmt.Println("INFO: Creating store key done.")
+	return nil
+}
+
+// gokiEncryptionFlags() returns the --enterprise-encryption flags of "cockroach start" for all stores.
+func gokiEncryptionFlags(e *gokiEncryptionMetadata, stores int) []string {
+	if stores < 1 {
+		stores = 1
+	}
+	flags := []string{}
+	for k := 1; k <= stores; k++ {
+		flags = append(flags, "--enterprise-encryption=path="+gokiStorePath(k)+",key="+e.Key+",old-key="+e.OldKey)
+	}
+	return flags
+}
+
+// rotateGokiEncryptionKey() generates a new store key, and re-creates the nodes one at a time with the new key.
+// The metadata records the nodes that are not restarted yet. If the previous rotation failed in the middle,
+// it restarts the rest of the nodes with the same key instead of generating a new one. Otherwise, the old-key of the new key
+// would not be the active key of them.
+func rotateGokiEncryptionKey(m *gokiMetadata, keySize int) error {
+	// All nodes must be running, so that the cluster keeps available during the rolling restart.
+	for _, n := range m.Nodes {
+		if dead, err := gokiIsDead(n.Id); err != nil {
+			return err
+		} else if dead {
+			fmt.Fprintf(os.Stderr, "ERROR: %v is not running.\n", n.Name)
+			fmt.Fprintf(os.Stderr, "HINT: Please start it by \"goki revive -g %d\" command before rotating the store key.\n", n.Id)
+			return errors.New("the node is not running")
+		}
+	}
+
+	if len(m.Encryption.Pending) != 0 {
+		fmt.Println("INFO: The previous rotation was not completed. Restart the rest of the nodes with the store key " + m.Encryption.Key + ".")
+	} else {
+		e := newGokiEncryptionKey(keySize, m.Encryption.Key)
+		if err := createGokiEncryptionKey(e); err != nil {
+			return err
+		}
+		for _, n := range m.Nodes {
+			e.Pending = append(e.Pending, n.Id)
+		}
+
+		// Save the metadata before restarting nodes. The nodes that are not restarted yet use the old key as the active key,
+		// so the new metadata (key=new, old-key=old) also works for them if they are restarted by other commands.
+		m.Encryption = e
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("INFO: *** Start Rotating Store Key ***")
+	for _, n := range m.Nodes {
+		if !containsGokiNodeId(m.Encryption.Pending, n.Id) {
+			continue
+		}
+		// Restart the next node after the ranges on the previous one are fully replicated again,
+		// so that the cluster does not lose the quorum of any range.
+		if err := waitGokiReplication(m); err != nil {
+			return err
+		}
+		if err := recreateGokiNode(m, n, n); err != nil {
+			return err
+		}
+
+		pending := []int{}
+		for _, id := range m.Encryption.Pending {
+			if id != n.Id {
+				pending = append(pending, id)
+			}
+		}
+		m.Encryption.Pending = pending
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+	}
+	fmt.Println("*** Rotating Store Key done ***")
+	return nil
+}
+
+func containsGokiNodeId(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// waitGokiReplication() waits until there is no under-replicated range, and times out after gokiReplicationTimeout.
+func waitGokiReplication(m *gokiMetadata) error {
+	return runGokiPhase("Waiting for the replication", gokiReplicationTimeout, func() error {
+		host, err := gokiRunningNode(m)
+		if err != nil {
+			return err
+		}
+		for {
+			output, err := execGokiSql(host, "csv",
+				"SELECT coalesce(sum((metrics->>'ranges.underreplicated')::INT), 0) FROM crdb_internal.kv_store_status",
+			)
+			lines := strings.Fields(string(output))
+			if err == nil && len(lines) != 0 && lines[len(lines)-1] == "0" {
+				return nil
+			}
+			if err == nil && len(lines) != 0 {
+				fmt.Println("INFO: Waiting for " + lines[len(lines)-1] + " under-replicated ranges.")
+			}
+			if err := gokiSleep(gokiReplicationInterval); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// showGokiEncryptionStatus() shows the active store key and whether each store is encrypted.
+func showGokiEncryptionStatus(m *gokiMetadata) error {
+	host, err := gokiRunningNode(m)
+	if err != nil {
+		return err
+	}
+
+	output, err := execGokiSql(host, "csv",
+		"SELECT node_id, store_id, properties->>'encrypted', metrics->>'rocksdb.encryption.algorithm' FROM crdb_internal.kv_store_status ORDER BY node_id, store_id",
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting the encryption status failed.\n Error is: %v\n", string(output))
+		return err
+	}
+	records, err := csv.NewReader(bytes.NewReader(output)).ReadAll()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Parsing the encryption status failed.\n Error is: %v\n", err)
+		return err
+	}
+
+	fmt.Println("Active store key: " + m.Encryption.Key + " (AES-" + strconv.Itoa(m.Encryption.KeySize) + ", generated at " + m.Encryption.RotatedAt.Local().Format(time.RFC3339) + ")")
+	fmt.Println("Old store key: " + m.Encryption.OldKey)
+	fmt.Println("")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE_ID\tSTORE_ID\tENCRYPTED\tALGORITHM")
+	for i, record := range records {
+		if i == 0 || len(record) != 4 {
+			continue
+		}
+		algorithm := record[3]
+		if n, err := strconv.Atoi(record[3]); err == nil && 0 <= n && n < len(gokiEncryptionAlgorithms) {
+			algorithm = gokiEncryptionAlgorithms[n]
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", record[0], record[1], orDash(record[2]), orDash(algorithm))
+	}
+	w.Flush()
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(encryptionCmd)
+	encryptionCmd.AddCommand(encryptionRotateCmd)
+	encryptionCmd.AddCommand(encryptionStatusCmd)
+	// Flags of goki encryption rotate.
+	encryptionRotateCmd.Flags().IntVar(&encryptionRotateCmdFlags.keySize, "key-size", gokiEncryptionKeySize, "Size of AES key of the new store key (128, 192, or 256). By default, the same size as the current key.")
+}
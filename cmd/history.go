@@ -0,0 +1,107 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	gokiHistoryFile     string = "history.log" // File name of action history in the Goki config directory.
+	gokiRedactedMessage string = "<redacted>"  // Replacement of passwords.
+)
+
+// Flags whose values are redacted in the action history.
+var gokiSecretFlags = []string{"--root-password", "--non-root-password", "--password", "-p"}
+
+func gokiHistoryPath() (string, error) {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), gokiHistoryFile), nil
+}
+
+// recordGokiHistory() appends the executed command and its result to the action history.
+// Recording the history is best-effort. So, it ignores errors.
+func recordGokiHistory(args []string, result error) {
+	path, err := gokiHistoryPath()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	status := "ok"
+	if result != nil {
+		status = "error: " + result.Error()
+	}
+	f.WriteString(time.Now().Format(time.RFC3339) + "\tgoki " + strings.Join(redactGokiArgs(args), " ") + "\t" + status + "\n")
+}
+
+// readGokiHistory() returns the last n lines of the action history.
+func readGokiHistory(n int) ([]string, error) {
+	path, err := gokiHistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lines := strings.FieldsFunc(string(b), func(r rune) bool { return r == '\n' })
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// redactGokiArgs() replaces values of the password flags with gokiRedactedMessage.
+func redactGokiArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i := 0; i < len(redacted); i++ {
+		for _, flag := range gokiSecretFlags {
+			if redacted[i] == flag && i+1 < len(redacted) {
+				redacted[i+1] = gokiRedactedMessage
+				i++
+				break
+			} else if strings.HasPrefix(redacted[i], flag+"=") {
+				redacted[i] = flag + "=" + gokiRedactedMessage
+				break
+			} else if len(flag) == 2 && len(redacted[i]) > 2 && strings.HasPrefix(redacted[i], flag) {
+				// The shorthand flag with the value (e.g. -pfoo).
+				redacted[i] = flag + gokiRedactedMessage
+				break
+			}
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Flag value of image pull command.
+var imagePullCmdFlags struct {
+	crdbOnly bool // If true, do not pull the images of the load balancer and monitoring.
+}
+
+// Flag value of image save command.
+var imageSaveCmdFlags struct {
+	output   string // Path of the tarball.
+	crdbOnly bool   // If true, do not save the images of the load balancer and monitoring.
+}
+
+// Flag value of image load command.
+var imageLoadCmdFlags struct {
+	input string // Path of the tarball.
+}
+
+// Images of the load balancer and monitoring that "goki image" handles with CockroachDB images.
+var gokiToolImages = []string{gokiHaproxyImage, gokiPrometheusImage, gokiGrafanaImage}
+
+// imageCmd represents the image command
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manage the local cache of CockroachDB images",
+	Long: `The "goki image" command pulls CockroachDB images in advance, and moves them as tarballs,
+so that "goki create" works quickly and without network access (e.g. on air-gapped laptops).
+By default, the images of the load balancer (HAProxy) and monitoring (Prometheus and Grafana) are also included.`,
+}
+
+// imagePullCmd represents the image pull command
+var imagePullCmd = &cobra.Command{
+	Use:   "pull [version]...",
+	Short: "Pull CockroachDB images",
+	Long: `The "goki image pull" command pulls the images of the versions of CockroachDB,
+and the images of the load balancer (HAProxy) and monitoring (Prometheus and Grafana).
+* By default, it pulls the default version of Goki.
+    goki image pull
+* You can specify the versions.
+    goki image pull v23.1.20 v23.2.4
+* You can pull only CockroachDB images with --crdb-only flag.
+    goki image pull --crdb-only
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		for _, image := range gokiImagesOf(args, imagePullCmdFlags.crdbOnly) {
+			fmt.Println("INFO: Pulling " + image + ".")
+			c := gokiCommand("docker", "pull", image)
+			if output, err := c.CombinedOutput(); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: docker pull command failed.\n Error is: %v\n", string(output))
+				return err
+			}
+		}
+		fmt.Println("INFO: Pulling images done.")
+
+		return nil
+	},
+}
+
+// imageSaveCmd represents the image save command
+var imageSaveCmd = &cobra.Command{
+	Use:   "save [version]...",
+	Short: "Save CockroachDB images to a tarball",
+	Long: `The "goki image save" command saves the images of the versions of CockroachDB to a tarball,
+with the images of the load balancer (HAProxy) and monitoring (Prometheus and Grafana).
+The images must be pulled in advance.
+* By default, it saves the default version of Goki to goki-images.tar.
+    goki image save
+* You can specify the versions and the path with -o (--output) flag.
+    goki image save v23.1.20 v23.2.4 -o cockroach.tar
+* You can save only CockroachDB images with --crdb-only flag.
+    goki image save --crdb-only
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		images := gokiImagesOf(args, imageSaveCmdFlags.crdbOnly)
+		for _, image := range images {
+			if err := checkCrdbImageExists(image); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("INFO: Saving " + strings.Join(images, ", ") + " to " + imageSaveCmdFlags.output + ".")
+		c := gokiCommand("docker", append([]string{"save", "-o", imageSaveCmdFlags.output}, images...)...)
+		if output, err := c.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker save command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+		fmt.Println("INFO: Saving images done.")
+
+		return nil
+	},
+}
+
+// imageLoadCmd represents the image load command
+var imageLoadCmd = &cobra.Command{
+	Use:   "load",
+	Short: "Load CockroachDB images from a tarball",
+	Long: `The "goki image load" command loads the images from the tarball created by "goki image save".
+It loads all images in the tarball, including the images of the load balancer and monitoring.
+* By default, it loads goki-images.tar.
+    goki image load
+* You can specify the path with -i (--input) flag.
+    goki image load -i cockroach.tar
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		c := gokiCommand("docker", "load", "-i", imageLoadCmdFlags.input)
+		output, err := c.CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker load command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+		fmt.Print(string(output))
+
+		return nil
+	},
+}
+
+// imageLsCmd represents the image ls command
+var imageLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List CockroachDB images available locally",
+	Long:  `The "goki image ls" command shows the versions of CockroachDB whose images are available locally (without network access).`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		c := gokiCommand("docker", "images", crdbContainerImage, "--format", "{{.Tag}}\t{{.ID}}\t{{.CreatedSince}}\t{{.Size}}")
+		output, err := c.CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: docker images command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tIMAGE ID\tCREATED\tSIZE\t")
+		for _, line := range strings.FieldsFunc(string(output), func(r rune) bool { return r == '\n' }) {
+			note := ""
+			if strings.HasPrefix(line, crdbVersion+"\t") {
+				note = "(default)"
+			}
+			fmt.Fprintf(w, "%v\t%v\n", line, note)
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+// crdbImagesOf() returns the images of the versions. If there is no version, it returns the image of the default version.
+func crdbImagesOf(versions []string) []string {
+	if len(versions) == 0 {
+		versions = []string{crdbVersion}
+	}
+	images := []string{}
+	for _, v := range versions {
+		images = append(images, crdbContainerImage+":"+v)
+	}
+	return images
+}
+
+// gokiImagesOf() returns the images of the versions of CockroachDB, and the images of the load balancer and monitoring.
+// If crdbOnly is true, it returns only the images of CockroachDB.
+func gokiImagesOf(versions []string, crdbOnly bool) []string {
+	images := crdbImagesOf(versions)
+	if !crdbOnly {
+		images = append(images, gokiToolImages...)
+	}
+	return images
+}
+
+// checkCrdbImageExists() checks the image is available locally.
+func checkCrdbImageExists(image string) error {
+	c := gokiCommand("docker", "image", "inspect", "--format", "{{.Id}}", image)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: The image %v is not available locally.\n Error is: %v", image, string(output))
+		if strings.HasPrefix(image, crdbContainerImage+":") {
+			fmt.Fprintf(os.Stderr, "HINT: Please pull it by \"goki image pull %v\" command.\n", strings.TrimPrefix(image, crdbContainerImage+":"))
+		} else {
+			fmt.Fprintln(os.Stderr, "HINT: Please pull it by \"goki image pull\" command, or save only CockroachDB images with --crdb-only flag.")
+		}
+		return err
+	}
+	return nil
+}
+
+// prepareCrdbImage() checks the image is available locally before creating the cluster. If not, it pulls the image.
+// If pulling fails (e.g. without network access), it shows the versions available locally.
+func prepareCrdbImage(image string) error {
+	if err := gokiCommand("docker", "image", "inspect", "--format", "{{.Id}}", image).Run(); err == nil {
+		return nil
+	}
+
+	fmt.Println("INFO: The image " + image + " is not available locally. Pulling it.")
+	c := gokiCommand("docker", "pull", image)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: The image %v is not available locally, and pulling it failed.\n Error is: %v", image, string(output))
+
+		if gokiContains(gokiToolImages, image) {
+			fmt.Fprintln(os.Stderr, "HINT: Please load the image by \"goki image load\" command. \"goki image save\" includes it.")
+			return errors.New("the image " + image + " is not available")
+		}
+		// The versions available locally are meaningful only for the official image. A custom image (--image) has its own tags.
+		if !strings.HasPrefix(image, crdbContainerImage+":") {
+			fmt.Fprintln(os.Stderr, "HINT: Please check the value of --image flag, or load the image by \"docker load\" command.")
+			return errors.New("the image " + image + " is not available")
+		}
+
+		available := []string{}
+		if output, err := gokiCommand("docker", "images", crdbContainerImage, "--format", "{{.Tag}}").CombinedOutput(); err == nil {
+			available = strings.Fields(string(output))
+		}
+		if len(available) != 0 {
+			fmt.Fprintf(os.Stderr, "HINT: The following versions are available locally: %v\n", strings.Join(available, ", "))
+			fmt.Fprintln(os.Stderr, "      Please specify one of them with --crdb-version flag, or load the image by \"goki image load\" command.")
+		} else {
+			fmt.Fprintln(os.Stderr, "HINT: There is no CockroachDB image locally. Please load the image by \"goki image load\" command.")
+		}
+		return errors.New("the image " + image + " is not available")
+	}
+	fmt.Println("INFO: Pulling " + image + " done.")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(imageCmd)
+	imageCmd.AddCommand(imagePullCmd)
+	imageCmd.AddCommand(imageSaveCmd)
+	imageCmd.AddCommand(imageLoadCmd)
+	imageCmd.AddCommand(imageLsCmd)
+	// Flags of goki image pull.
+	imagePullCmd.Flags().BoolVar(&imagePullCmdFlags.crdbOnly, "crdb-only", false, "Pull only CockroachDB images (without the load balancer and monitoring).")
+	// Flags of goki image save.
+	imageSaveCmd.Flags().StringVarP(&imageSaveCmdFlags.output, "output", "o", gokiResourceName+"-images.tar", "Path of the tarball.")
+	imageSaveCmd.Flags().BoolVar(&imageSaveCmdFlags.crdbOnly, "crdb-only", false, "Save only CockroachDB images (without the load balancer and monitoring).")
+	// Flags of goki image load.
+	imageLoadCmd.Flags().StringVarP(&imageLoadCmdFlags.input, "input", "i", gokiResourceName+"-images.tar", "Path of the tarball.")
+}
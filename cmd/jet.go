@@ -18,7 +18,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 
 	"github.com/spf13/cobra"
@@ -82,7 +81,7 @@ func killGokiContainer(id int) error {
 	container := gokiResourceName + "-" + strconv.Itoa(id)
 
 	// Kill specified container using "docker kill" command.
-	c := exec.Command("docker", "kill", container)
+	c := gokiCommand("docker", "kill", container)
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker kill command failed: %v\n", string(output))
 		return err
@@ -0,0 +1,239 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to load balancer
+	gokiHaproxyImage string = "haproxy:2.8" // HAProxy's image name and tag in the Docker Hub.
+	gokiLbIp         string = "127.0.0.1"   // IP that the load balancer will listen.
+	gokiLbSqlPort    int    = 26000         // Port that the load balancer will listen for SQL connection.
+	gokiLbWebUiPort  int    = 8090          // Port that the load balancer will listen for HTTP request (Web UI).
+)
+
+// Flag value of lb up command.
+var lbUpCmdFlags struct {
+	sqlPort   int // Published port of the load balancer for SQL connection.
+	webUiPort int // Published port of the load balancer for HTTP request (Web UI).
+}
+
+// lbCmd represents the lb command
+var lbCmd = &cobra.Command{
+	Use:   "lb",
+	Short: "Manage the load balancer in front of all nodes",
+	Long: `The "goki lb" command manages the load balancer (HAProxy) in front of all nodes.
+Host applications can keep connecting to the cluster via the load balancer, even if some nodes are killed by "goki jet".
+The load balancer container is also deleted by "goki delete" command.`,
+}
+
+// lbUpCmd represents the lb up command
+var lbUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Launch or update the load balancer",
+	Long: `The "goki lb up" command launches the load balancer that health-checks /health?ready=1 of each node.
+If the load balancer already exists, it updates the load balancer with the current nodes.
+* By default, the load balancer listens on 127.0.0.1:26000 for SQL, and 127.0.0.1:8090 for Web UI.
+    goki lb up
+* You can specify the ports with --sql-port and --http-port flag.
+    goki lb up --sql-port 36257 --http-port 18080
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiNetworkExists(); err != nil {
+			return err
+		}
+
+		if err := loadGokiCredential(); err != nil {
+			return err
+		}
+
+		if err := createGokiLb(lbUpCmdFlags.sqlPort, lbUpCmdFlags.webUiPort); err != nil {
+			return err
+		}
+
+		showLbAccessCommand(lbUpCmdFlags.sqlPort, lbUpCmdFlags.webUiPort)
+
+		return nil
+	},
+}
+
+// lbDownCmd represents the lb down command
+var lbDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Delete the load balancer",
+	Long:  `The "goki lb down" command deletes the load balancer container.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := deleteGokiLb(); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// createGokiLb() creates the load balancer. If it already exists, it is re-created with the current nodes.
+func createGokiLb(sqlPort int, webUiPort int) error {
+	fmt.Println("INFO: Creating load balancer start.")
+
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	}
+	ids, err := gokiNodeIds(m)
+	if err != nil {
+		return err
+	}
+
+	// Remove the existing load balancer to apply the current nodes and ports.
+	container := gokiResourceName + "-lb"
+	if err := removeContainerIfExists(container); err != nil {
+		return err
+	}
+
+	gokiCreated.addContainer(container)
+	c := gokiCommand("docker", "create",
+		"--name="+container,
+		"--hostname="+container,
+		"--network="+gokiResourceName+"-net",
+		"-p", gokiLbIp+":"+strconv.Itoa(sqlPort)+":26257",
+		"-p", gokiLbIp+":"+strconv.Itoa(webUiPort)+":8080",
+		"--label="+gokiResourceLabel,
+		gokiHaproxyImage,
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker create command that creating %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+
+	files := map[string][]byte{"haproxy.cfg": []byte(haproxyConfig(ids))}
+	if err := copyFilesToContainer(container, "/usr/local/etc/haproxy", files); err != nil {
+		return err
+	}
+
+	if err := startContainer(container); err != nil {
+		return err
+	}
+
+	// Store the addresses of the load balancer in the metadata.
+	if m != nil {
+		m.LoadBalancer = &gokiLbMetadata{
+			SqlAddr:   gokiLbIp + ":" + strconv.Itoa(sqlPort),
+			WebUiAddr: gokiLbIp + ":" + strconv.Itoa(webUiPort),
+		}
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("INFO: Creating load balancer done.")
+	return nil
+}
+
+func deleteGokiLb() error {
+	if err := removeContainerIfExists(gokiResourceName + "-lb"); err != nil {
+		return err
+	}
+	fmt.Println("The container " + gokiResourceName + "-lb was deleted.")
+
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	} else if m != nil && m.LoadBalancer != nil {
+		m.LoadBalancer = nil
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// haproxyConfig() returns the config of HAProxy that balances SQL and HTTP connections to all nodes.
+// The servers are resolved by Docker's DNS at runtime. So, HAProxy can start even if some nodes are dead.
+func haproxyConfig(ids []int) string {
+	var sqlServers, httpServers strings.Builder
+	for _, id := range ids {
+		node := gokiResourceName + "-" + strconv.Itoa(id)
+		sqlServers.WriteString("  server " + node + " " + node + ":26257 check port 8080 check-ssl verify none\n")
+		httpServers.WriteString("  server " + node + " " + node + ":8080 check check-ssl verify none\n")
+	}
+
+	return `global
+  maxconn 4096
+
+resolvers docker
+  nameserver dns 127.0.0.11:53
+  hold valid 10s
+
+defaults
+  mode tcp
+  timeout connect 10s
+  timeout client 30m
+  timeout server 30m
+  option clitcpka
+  default-server inter 2s fall 2 rise 2 init-addr last,libc,none resolvers docker
+
+frontend sql
+  bind :26257
+  default_backend sql_nodes
+
+backend sql_nodes
+  balance roundrobin
+  option httpchk GET /health?ready=1
+` + sqlServers.String() + `
+frontend http
+  bind :8080
+  default_backend http_nodes
+
+backend http_nodes
+  balance source
+  option httpchk GET /health
+` + httpServers.String()
+}
+
+func showLbAccessCommand(sqlPort int, webUiPort int) {
+	fmt.Printf("\nAccess DB via the load balancer as a non-root user:\n")
+	fmt.Printf("  postgresql://%v:<password>@%v:%v/defaultdb?sslmode=require\n", gokiCred.NonRootUserName, gokiLbIp, sqlPort)
+
+	fmt.Printf("\nAccess Web UI via the load balancer:\n")
+	fmt.Printf("  URL: https://%v:%v/\n\n", gokiLbIp, webUiPort)
+}
+
+// removeContainerIfExists() removes the container forcibly. It does nothing if the container does not exist.
+func removeContainerIfExists(container string) error {
+	c := gokiCommand("docker", "rm", "-f", container)
+	if output, err := c.CombinedOutput(); err != nil && !strings.Contains(string(output), "No such container") {
+		fmt.Fprintf(os.Stderr, "docker rm command failed: %v\n", string(output))
+		return err
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(lbCmd)
+	lbCmd.AddCommand(lbUpCmd)
+	lbCmd.AddCommand(lbDownCmd)
+	// Flags of goki lb up.
+	lbUpCmd.Flags().IntVar(&lbUpCmdFlags.sqlPort, "sql-port", gokiLbSqlPort, "Published port of the load balancer for SQL connection.")
+	lbUpCmd.Flags().IntVar(&lbUpCmdFlags.webUiPort, "http-port", gokiLbWebUiPort, "Published port of the load balancer for HTTP request (Web UI).")
+}
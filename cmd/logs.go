@@ -0,0 +1,390 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Sources of goki logs.
+	gokiLogSourceDocker string = "docker" // Output of "docker logs" (stdout and stderr of cockroach).
+	gokiLogSourceFile   string = "file"   // Log files in the data volume of the node.
+	// Directory of the log files in the node's data volume.
+	gokiLogDir string = "/cockroach/cockroach-data/logs"
+)
+
+// Severities of CockroachDB's log in ascending order.
+var gokiLogSeverities = []string{"INFO", "WARNING", "ERROR", "FATAL"}
+
+// Channels of CockroachDB's log. The index is the channel number in the log entry.
+var gokiLogChannels = []string{
+	"DEV", "OPS", "HEALTH", "STORAGE", "SESSIONS", "SQL_SCHEMA", "USER_ADMIN", "PRIVILEGES",
+	"SENSITIVE_ACCESS", "SQL_EXEC", "SQL_PERF", "SQL_INTERNAL_PERF", "TELEMETRY", "KV_DISTRIBUTION",
+}
+
+// Colors of the prefix of each container, when goki logs shows logs of multiple containers.
+var gokiLogColors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[31m"}
+
+// Flag value of logs command.
+var logsCmdFlags struct {
+	gokiId   int    // Number of node (container).
+	all      bool   // If true, show logs of all nodes.
+	client   bool   // If true, show logs of client container.
+	follow   bool   // If true, follow new logs.
+	since    string // Show logs since timestamp or relative (e.g. 5m).
+	tail     int    // Number of lines from the end of logs. Negative value means all.
+	source   string // Source of logs (docker or file).
+	severity string // Minimum severity of logs.
+	channels string // Comma separated channels of logs.
+}
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show logs of nodes or client container",
+	Long: `The "goki logs" command shows logs of nodes (CockroachDB) or client container.
+* By default, it shows the last 100 lines of "docker logs" of Node 1.
+    goki logs
+* You can specify the Node ID with -g (--goki) flag, or all nodes with --all flag.
+  Logs of multiple nodes are interleaved with colored prefixes.
+    goki logs -g 3
+    goki logs --all
+* You can follow new logs with -f (--follow) flag, and show logs since a time with --since flag.
+    goki logs --all -f --since 5m
+* You can show the CockroachDB's log files in the node's data volume with --source file.
+  It works even if the node is dead.
+    goki logs -g 2 --source file
+* You can filter logs by the minimum severity and channels.
+    goki logs --all --source file --severity WARNING --channel OPS,HEALTH
+* You can show logs of client container with --client flag.
+    goki logs --client
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		filter, err := newGokiLogFilter()
+		if err != nil {
+			return err
+		}
+
+		containers, err := gokiLogTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := showGokiLogs(containers, filter); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// gokiLogTargets() returns names of containers that goki logs shows.
+func gokiLogTargets(cmd *cobra.Command) ([]string, error) {
+	if logsCmdFlags.all && cmd.Flags().Changed("goki") {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The -g (--goki) flag cannot be used with --all flag.")
+		return nil, errors.New("invalid argument. The -g (--goki) flag cannot be used with --all flag")
+	}
+
+	if logsCmdFlags.source != gokiLogSourceDocker && logsCmdFlags.source != gokiLogSourceFile {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the source docker or file.")
+		return nil, errors.New("invalid argument. Please specify the source docker or file")
+	}
+
+	if logsCmdFlags.client {
+		if logsCmdFlags.source == gokiLogSourceFile {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The client container does not have log files.")
+			return nil, errors.New("invalid argument. The client container does not have log files")
+		}
+		return []string{gokiResourceName + "-client"}, nil
+	}
+
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return nil, err
+	} else if m != nil && m.MemStore != "" && logsCmdFlags.source == gokiLogSourceFile {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The nodes with in-memory stores do not have log files.")
+		fmt.Fprintln(os.Stderr, "HINT: Please use --source docker.")
+		return nil, errors.New("invalid argument. The nodes with in-memory stores do not have log files")
+	}
+
+	if !logsCmdFlags.all {
+		if err := checkGokiNodeId(m, logsCmdFlags.gokiId); err != nil {
+			return nil, err
+		}
+		return []string{gokiResourceName + "-" + strconv.Itoa(logsCmdFlags.gokiId)}, nil
+	}
+
+	ids, err := gokiNodeIds(m)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, id := range ids {
+		names = append(names, gokiResourceName+"-"+strconv.Itoa(id))
+	}
+	return names, nil
+}
+
+// showGokiLogs() streams logs of the containers concurrently.
+func showGokiLogs(containers []string, filter *gokiLogFilter) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(containers))
+
+	for i, container := range containers {
+		// Show the prefix only if goki logs shows multiple containers.
+		prefix := ""
+		if len(containers) > 1 {
+			prefix = colorize(gokiLogColors[i%len(gokiLogColors)], fmt.Sprintf("%-*s |", len(gokiResourceName)+2, container)) + " "
+		}
+
+		// Each container has its own filter, because the filter has the state of the previous entry.
+		f := *filter
+
+		wg.Add(1)
+		go func(i int, container string, prefix string) {
+			defer wg.Done()
+			errs[i] = streamGokiLog(gokiLogCommand(container), &f, func(line string) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintln(os.Stdout, prefix+line)
+			})
+		}(i, container, prefix)
+	}
+	wg.Wait()
+
+	// Remove the temporary containers, in case docker was killed before it removed them (e.g. by the second SIGINT).
+	if logsCmdFlags.source == gokiLogSourceFile {
+		runGokiCleanup(func() {
+			for _, container := range containers {
+				gokiCommand("docker", "rm", "-f", gokiLogHelperName(container)).Run()
+			}
+		})
+	}
+
+	// Ctrl-C (SIGINT) is the usual way to stop following logs.
+	if logsCmdFlags.follow && gokiRootCtx.Err() != nil {
+		return nil
+	}
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Getting logs of %v failed.\n Error is: %v\n", containers[i], err)
+			return err
+		}
+	}
+	return nil
+}
+
+// gokiLogCommand() returns the command that outputs logs of the container.
+func gokiLogCommand(container string) *exec.Cmd {
+	// While following logs, streamGokiLog() stops docker by SIGINT, and docker stops the temporary container gracefully.
+	// If Goki killed docker by the context instead, the temporary container would be left.
+	command := gokiCommand
+	if logsCmdFlags.follow {
+		command = exec.Command
+	}
+
+	if logsCmdFlags.source == gokiLogSourceDocker {
+		args := []string{"logs", "--tail", "all"}
+		if logsCmdFlags.tail >= 0 {
+			args[2] = strconv.Itoa(logsCmdFlags.tail)
+		}
+		if logsCmdFlags.follow {
+			args = append(args, "--follow")
+		}
+		if logsCmdFlags.since != "" {
+			args = append(args, "--since", logsCmdFlags.since)
+		}
+		return command("docker", append(args, container)...)
+	}
+
+	// Read the log files from the node's data volume by a temporary container.
+	// So, Goki can show logs even if the node is dead.
+	// The symbolic links (e.g. cockroach.log) point the latest log file of each file group.
+	tail := "tail -q -n +1"
+	if logsCmdFlags.tail >= 0 {
+		tail = "tail -q -n " + strconv.Itoa(logsCmdFlags.tail)
+	}
+	if logsCmdFlags.follow {
+		tail += " -F"
+	}
+	volume := strings.Replace(container, gokiResourceName+"-", gokiResourceName+"-volume-", 1)
+	// The init process (--init) forwards SIGINT to tail, because tail as PID 1 would ignore it.
+	return command("docker", "run", "--rm", "--init",
+		"--name="+gokiLogHelperName(container),
+		"--mount=type=volume,src="+volume+",dst=/cockroach/cockroach-data,readonly",
+		"--label="+gokiHelperLabel,
+		"--entrypoint=sh",
+		gokiImage(),
+		"-c", "exec "+tail+" $(find "+gokiLogDir+" -maxdepth 1 -type l -name 'cockroach*.log')",
+	)
+}
+
+// gokiLogHelperName() returns the name of the temporary container that reads the log files of the container.
+// It includes the process ID, so that multiple "goki logs" can read the same node.
+func gokiLogHelperName(container string) string {
+	return container + "-logs-" + strconv.Itoa(os.Getpid())
+}
+
+// streamGokiLog() runs the command, and calls write for each line that matches the filter.
+func streamGokiLog(c *exec.Cmd, filter *gokiLogFilter, write func(string)) error {
+	pr, pw := io.Pipe()
+	c.Stdout = pw
+	c.Stderr = pw
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		pw.CloseWithError(c.Wait())
+		close(done)
+	}()
+	// Forward the interruption (e.g. SIGTERM that only Goki received) to docker, so that it stops gracefully.
+	go func() {
+		select {
+		case <-gokiRootCtx.Done():
+			c.Process.Signal(os.Interrupt)
+		case <-done:
+		}
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if filter.match(scanner.Text()) {
+			write(scanner.Text())
+		}
+	}
+	return scanner.Err()
+}
+
+// Filter of log entries. A line without the entry header (e.g. stack trace) follows the previous entry.
+type gokiLogFilter struct {
+	minSeverity int          // Index of gokiLogSeverities.
+	channels    map[int]bool // Channel numbers to show. Empty means all.
+	since       time.Time    // Show entries since this time. Zero means all.
+	matched     bool         // Whether the previous entry matched or not.
+}
+
+func newGokiLogFilter() (*gokiLogFilter, error) {
+	f := &gokiLogFilter{channels: map[int]bool{}, matched: true}
+
+	f.minSeverity = -1
+	for i, s := range gokiLogSeverities {
+		if strings.EqualFold(s, logsCmdFlags.severity) {
+			f.minSeverity = i
+		}
+	}
+	if f.minSeverity < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the severity INFO, WARNING, ERROR, or FATAL.")
+		return nil, errors.New("invalid argument. Please specify the severity INFO, WARNING, ERROR, or FATAL")
+	}
+
+	if logsCmdFlags.channels != "" {
+		for _, name := range strings.Split(logsCmdFlags.channels, ",") {
+			found := false
+			for i, c := range gokiLogChannels {
+				if strings.EqualFold(c, strings.TrimSpace(name)) {
+					f.channels[i] = true
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. Unknown channel %v. Please specify the channels in %v.\n", name, strings.Join(gokiLogChannels, ", "))
+				return nil, errors.New("invalid argument. Unknown channel " + name)
+			}
+		}
+	}
+
+	// The "docker logs" command filters logs by --since flag. So, filter the log files only.
+	if logsCmdFlags.since != "" && logsCmdFlags.source == gokiLogSourceFile {
+		if d, err := time.ParseDuration(logsCmdFlags.since); err == nil {
+			f.since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, logsCmdFlags.since); err == nil {
+			f.since = t
+		} else {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify --since as a duration (e.g. 5m) or RFC 3339 time.")
+			return nil, errors.New("invalid argument. Please specify --since as a duration (e.g. 5m) or RFC 3339 time")
+		}
+	}
+
+	return f, nil
+}
+
+// match() checks the line of log. The header of entry in crdb-v2 format is as follows:
+//
+//	I220101 00:00:00.123456 123 1@server/server.go:123 ⋮ [n1] 1 message
+//
+// The first letter is the severity, and the number before "@" is the channel (DEV if omitted).
+func (f *gokiLogFilter) match(line string) bool {
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 5 || len(fields[0]) != 7 || !strings.ContainsRune("IWEF", rune(fields[0][0])) {
+		return f.matched
+	}
+	t, err := time.Parse("060102 15:04:05.999999", fields[0][1:]+" "+fields[1])
+	if err != nil {
+		return f.matched
+	}
+
+	severity := strings.IndexByte("IWEF", fields[0][0])
+	channel := 0
+	if i := strings.IndexByte(fields[3], '@'); i > 0 {
+		channel, _ = strconv.Atoi(fields[3][:i])
+	}
+
+	f.matched = severity >= f.minSeverity &&
+		(len(f.channels) == 0 || f.channels[channel]) &&
+		(f.since.IsZero() || !t.Before(f.since))
+	return f.matched
+}
+
+// gokiImage() returns the container image of the cluster in the metadata, or the default image.
+func gokiImage() string {
+	if m, err := loadGokiMetadata(); err == nil && m != nil && m.Image != "" {
+		return m.Image
+	} else if err == nil && m != nil && m.CrdbVersion != "" {
+		return crdbContainerImage + ":" + m.CrdbVersion
+	}
+	return crdbContainerImage + ":" + crdbVersion
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	// Flags of goki logs.
+	logsCmd.Flags().IntVarP(&logsCmdFlags.gokiId, "goki", "g", 1, "The node ID of container that goki logs command will show.")
+	logsCmd.Flags().BoolVar(&logsCmdFlags.all, "all", false, "Show logs of all nodes.")
+	logsCmd.Flags().BoolVar(&logsCmdFlags.client, "client", false, "Show logs of client container.")
+	logsCmd.Flags().BoolVarP(&logsCmdFlags.follow, "follow", "f", false, "Follow new logs.")
+	logsCmd.Flags().StringVar(&logsCmdFlags.since, "since", "", "Show logs since timestamp (e.g. 2022-01-01T00:00:00Z) or relative (e.g. 5m).")
+	logsCmd.Flags().IntVar(&logsCmdFlags.tail, "tail", 100, "Number of lines to show from the end of logs. Negative value means all.")
+	logsCmd.Flags().StringVar(&logsCmdFlags.source, "source", gokiLogSourceDocker, "Source of logs (docker or file).")
+	logsCmd.Flags().StringVar(&logsCmdFlags.severity, "severity", "INFO", "Minimum severity of logs (INFO, WARNING, ERROR, or FATAL).")
+	logsCmd.Flags().StringVar(&logsCmdFlags.channels, "channel", "", "Comma separated channels of logs (e.g. OPS,HEALTH). Default is all channels.")
+}
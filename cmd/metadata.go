@@ -0,0 +1,209 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cluster metadata that Goki stores in the user's config directory.
+// Goki reads it in other commands to know the cluster that "goki create" created.
+type gokiMetadata struct {
+	CrdbVersion  string                  `json:"crdb_version"`            // Version of CockroachDB (Tag of container image).
+	Image        string                  `json:"image,omitempty"`         // Container image of CockroachDB.
+	Binary       string                  `json:"binary,omitempty"`        // Path of the locally built cockroach binary, if it is mounted.
+	Locality     bool                    `json:"locality"`                // Whether set --locality flag or not.
+	Stores       int                     `json:"stores,omitempty"`        // Number of stores per node.
+	MemStore     string                  `json:"mem_store,omitempty"`     // Store spec of in-memory stores, if the nodes do not have volumes.
+	Encryption   *gokiEncryptionMetadata `json:"encryption,omitempty"`    // Store keys of encryption at rest, if it is enabled.
+	Nodes        []gokiNodeMetadata      `json:"nodes"`                   // Each node (container) of the cluster.
+	Credential   gokiCredential          `json:"credential"`              // Credentials of users that Goki set in the cluster.
+	CreatedAt    time.Time               `json:"created_at"`              // Time when "goki create" created the cluster.
+	LoadBalancer *gokiLbMetadata         `json:"load_balancer,omitempty"` // Load balancer in front of all nodes, if it exists.
+}
+
+// Metadata of each node (container).
+type gokiNodeMetadata struct {
+	Id           int               `json:"id"`                      // Node ID (suffix of container name).
+	Name         string            `json:"name"`                    // Container name.
+	Locality     string            `json:"locality"`                // Value of --locality flag.
+	CrdbVersion  string            `json:"crdb_version,omitempty"`  // Version of CockroachDB of the node (Tag of container image).
+	Image        string            `json:"image,omitempty"`         // Container image of CockroachDB of the node.
+	StartFlags   []string          `json:"start_flags,omitempty"`   // Extra flags of "cockroach start" (--start-flag).
+	Env          []string          `json:"env,omitempty"`           // Environment variables of the container (--env).
+	Cpus         string            `json:"cpus,omitempty"`          // Number of CPUs of the container (--cpus).
+	Memory       string            `json:"memory,omitempty"`        // Memory limit of the container (--memory).
+	DiskThrottle *gokiDiskThrottle `json:"disk_throttle,omitempty"` // Block I/O throttling that "goki disk slow" set.
+	SqlAddr      string            `json:"sql_addr,omitempty"`      // Published address for SQL connection.
+	WebUiAddr    string            `json:"web_ui_addr,omitempty"`   // Published address for HTTP request (Web UI).
+}
+
+// Metadata of the load balancer.
+type gokiLbMetadata struct {
+	SqlAddr   string `json:"sql_addr"`    // Published address for SQL connection.
+	WebUiAddr string `json:"web_ui_addr"` // Published address for HTTP request (Web UI).
+}
+
+// newGokiMetadata() creates the metadata of the cluster that "goki create" is creating.
+func newGokiMetadata() *gokiMetadata {
+	m := &gokiMetadata{
+		CrdbVersion: gokiImageTag(gokiCrdbImage()),
+		Image:       gokiCrdbImage(),
+		Binary:      createCmdFlags.binary,
+		Locality:    createCmdFlags.locality,
+		Stores:      createCmdFlags.stores,
+		MemStore:    createCmdFlags.memStore,
+		Encryption:  gokiEncryption,
+		Credential:  gokiCred,
+		CreatedAt:   time.Now(),
+	}
+
+	for i := 1; i <= createCmdFlags.node; i++ {
+		n := gokiNodeMetadata{
+			Id:          i,
+			Name:        gokiResourceName + "-" + strconv.Itoa(i),
+			Locality:    gokiLocality(i, createCmdFlags.locality),
+			CrdbVersion: gokiImageTag(gokiNodeCrdbImage(i)),
+			Image:       gokiNodeCrdbImage(i),
+			StartFlags:  gokiNodeStartFlags(i),
+			Env:         gokiNodeEnv(i),
+			Cpus:        gokiNodeCpus(i),
+			Memory:      gokiNodeMemory(i),
+		}
+		// Only the first node publishes its ports.
+		if i == 1 {
+			n.SqlAddr = gokiSqlIp + ":" + gokiSqlPort
+			n.WebUiAddr = gokiWebUiIp + ":" + gokiWebUiPort
+		}
+		m.Nodes = append(m.Nodes, n)
+	}
+	return m
+}
+
+// gokiImageTag() returns the tag of the image (e.g. "v23.2.4" of "cockroachdb/cockroach:v23.2.4"). If there is no tag, it returns "latest".
+func gokiImageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// checkGokiNodeId() checks the node exists in the cluster, if the metadata exists.
+func checkGokiNodeId(m *gokiMetadata, id int) error {
+	if m == nil {
+		return nil
+	}
+	if id < 1 || len(m.Nodes) < id {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The cluster has %d nodes. Please specify the node ID between 1 and %d.\n", len(m.Nodes), len(m.Nodes))
+		return errors.New("invalid argument. The specified node does not exist")
+	}
+	return nil
+}
+
+// gokiNodeIds() returns IDs of all nodes in the metadata.
+// If there is no metadata (e.g. the cluster was created by old Goki),
+// it assumes that all containers named "goki-<number>" are nodes.
+func gokiNodeIds(m *gokiMetadata) ([]int, error) {
+	ids := []int{}
+	if m != nil {
+		for _, node := range m.Nodes {
+			ids = append(ids, node.Id)
+		}
+		return ids, nil
+	}
+
+	containers, err := getGokiContainers()
+	if err != nil {
+		return nil, err
+	}
+	return gokiNodeIdsOf(containers), nil
+}
+
+func gokiMetadataPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Getting user's config directory failed.\n Error is: %v\n", err)
+		return "", err
+	}
+	return filepath.Join(dir, gokiResourceName, gokiResourceName+".json"), nil
+}
+
+// loadGokiMetadata() returns nil (without error) if the metadata file does not exist.
+func loadGokiMetadata() (*gokiMetadata, error) {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Reading Goki metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+
+	var m gokiMetadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Parsing Goki metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+	return &m, nil
+}
+
+func saveGokiMetadata(m *gokiMetadata) error {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Encoding Goki metadata failed.\n Error is: %v\n", err)
+		return err
+	}
+
+	// The metadata includes passwords. So, only the owner can read it.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating Goki config directory failed.\n Error is: %v\n", err)
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Writing Goki metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
+
+func deleteGokiMetadata() error {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "ERROR: Removing Goki metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
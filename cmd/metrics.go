@@ -0,0 +1,448 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Output formats of goki metrics.
+	gokiMetricsCsv  string = "csv"
+	gokiMetricsJson string = "json"
+)
+
+// Quantiles that goki metrics calculates from histograms.
+var gokiMetricsQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Flag value of metrics record and snapshot command.
+var metricsCmdFlags struct {
+	duration time.Duration // Duration of recording.
+	interval time.Duration // Interval of polling.
+	metrics  string        // Comma separated metric names.
+	output   string        // Path of output file. Empty means stdout.
+	format   string        // Output format (csv or json).
+}
+
+// metricsCmd represents the metrics command
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Record metrics of nodes to CSV or JSON",
+	Long: `The "goki metrics" command polls the Prometheus endpoint (/_status/vars) of each node via the client container,
+and writes the metrics to CSV or JSON. It does not need the monitoring stack.
+For histograms (e.g. sql_txn_latency), it writes _count, _sum, and the quantiles _p50, _p90, and _p99.`,
+}
+
+// metricsRecordCmd represents the metrics record command
+var metricsRecordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record time series of metrics",
+	Long: `The "goki metrics record" command polls metrics of all nodes every interval during the duration.
+The quantiles of histograms are calculated from the observations between each poll.
+* By default, it records all metrics every 10 seconds for 1 minute to stdout as CSV.
+    goki metrics record
+* You can specify the duration, interval, metrics, and output file.
+  If the output file ends with .json, it writes JSON. To stop recording early, use Ctrl-C.
+    goki metrics record --duration 10m --metrics sql_txn_latency,ranges_underreplicated -o out.csv
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if metricsCmdFlags.duration <= 0 || metricsCmdFlags.interval <= 0 {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify positive --duration and --interval.")
+			return errors.New("invalid argument. Please specify positive --duration and --interval")
+		}
+
+		if err := runGokiMetrics(metricsCmdFlags.duration, metricsCmdFlags.interval); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// metricsSnapshotCmd represents the metrics snapshot command
+var metricsSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Dump metrics at a point in time",
+	Long: `The "goki metrics snapshot" command dumps metrics of all nodes at a point in time.
+The quantiles of histograms are calculated from all observations since each node started.
+* By default, it dumps all metrics to stdout as CSV.
+    goki metrics snapshot
+* You can specify the metrics, output file, and format.
+    goki metrics snapshot --metrics ranges_underreplicated,sql_conns --format json
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// A snapshot is a record that polls only once.
+		if err := runGokiMetrics(0, 0); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// A sample of metric.
+type gokiMetricSample struct {
+	Time   time.Time `json:"time"`   // Time when Goki polled the metric.
+	Node   string    `json:"node"`   // Container name of node.
+	Metric string    `json:"metric"` // Metric name in Prometheus format.
+	Labels string    `json:"labels"` // Labels of metric (e.g. store="1").
+	Value  float64   `json:"value"`  // Value of metric.
+}
+
+// A series in the Prometheus text format.
+type promSeries struct {
+	name   string
+	labels string
+	value  float64
+}
+
+// runGokiMetrics() polls metrics every interval during duration. If duration is 0, it polls only once.
+func runGokiMetrics(duration time.Duration, interval time.Duration) error {
+	format, err := gokiMetricsFormat()
+	if err != nil {
+		return err
+	}
+
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	}
+	ids, err := gokiNodeIds(m)
+	if err != nil {
+		return err
+	}
+
+	if err := checkGokiCurl(); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if metricsCmdFlags.output != "" {
+		f, err := os.Create(metricsCmdFlags.output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Creating output file %v failed.\n Error is: %v\n", metricsCmdFlags.output, err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	names := []string{}
+	if metricsCmdFlags.metrics != "" {
+		for _, name := range strings.Split(metricsCmdFlags.metrics, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if format == gokiMetricsCsv {
+		cw.Write([]string{"time", "node", "metric", "labels", "value"})
+	}
+	samples := []gokiMetricSample{}
+
+	prev := map[string][]promSeries{}
+	end := time.Now().Add(duration)
+	for {
+		now := time.Now()
+		for _, id := range ids {
+			node := gokiResourceName + "-" + strconv.Itoa(id)
+			series, err := scrapeGokiNode(node)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "INFO: Skip %v. Scraping metrics failed: %v\n", node, err)
+				continue
+			}
+			for _, s := range selectGokiMetrics(series, prev[node], names) {
+				sample := gokiMetricSample{Time: now, Node: node, Metric: s.name, Labels: s.labels, Value: s.value}
+				if format == gokiMetricsCsv {
+					cw.Write([]string{sample.Time.Format(time.RFC3339), sample.Node, sample.Metric, sample.Labels, strconv.FormatFloat(sample.Value, 'g', -1, 64)})
+				} else {
+					samples = append(samples, sample)
+				}
+			}
+			prev[node] = series
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Writing metrics failed.\n Error is: %v\n", err)
+			return err
+		}
+
+		if duration == 0 || !time.Now().Add(interval).Before(end.Add(time.Second)) {
+			break
+		}
+		stopped := false
+		select {
+		case <-sig:
+			stopped = true
+		case <-time.After(interval):
+		}
+		if stopped {
+			fmt.Fprintln(os.Stderr, "INFO: Recording metrics was stopped.")
+			break
+		}
+	}
+
+	if format == gokiMetricsJson {
+		return writeStructured(w, gokiOutputJson, samples)
+	}
+	return nil
+}
+
+// gokiMetricsFormat() returns the format by --format flag, or the extension of --output flag.
+func gokiMetricsFormat() (string, error) {
+	format := metricsCmdFlags.format
+	if format == "" {
+		format = gokiMetricsCsv
+		if strings.HasSuffix(metricsCmdFlags.output, ".json") {
+			format = gokiMetricsJson
+		}
+	}
+	if format != gokiMetricsCsv && format != gokiMetricsJson {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the format csv or json.")
+		return "", errors.New("invalid argument. Please specify the format csv or json")
+	}
+	return format, nil
+}
+
+// checkGokiCurl() checks that the client container has curl, since scrapeGokiNode() uses it.
+// The nodes do not publish the HTTP port by default, so Goki cannot get the metrics from the host.
+func checkGokiCurl() error {
+	ctx, cancel := context.WithTimeout(gokiContext(), gokiStatusTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, "docker", "exec", gokiResourceName+"-client", "curl", "--version")
+	if output, err := c.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "executable file not found") {
+			fmt.Fprintln(os.Stderr, "ERROR: The client container does not have curl. goki metrics gets the metrics of nodes by curl in the client container.")
+			fmt.Fprintln(os.Stderr, "HINT: Please use the image that has curl, or use \"goki monitoring up\" command instead.")
+			return errors.New("the client container does not have curl")
+		}
+		fmt.Fprintf(os.Stderr, "ERROR: docker exec command failed.\n Error is: %v\n", string(output))
+		return err
+	}
+	return nil
+}
+
+// scrapeGokiNode() gets /_status/vars of the node by curl in the client container.
+func scrapeGokiNode(node string) ([]promSeries, error) {
+	ctx, cancel := context.WithTimeout(gokiContext(), gokiStatusTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, "docker", "exec", gokiResourceName+"-client",
+		"curl", "-sSf",
+		"--cacert", "/cockroach/certs/ca.crt",
+		"https://"+node+":8080/_status/vars",
+	)
+	output, err := c.CombinedOutput()
+	if err != nil {
+		return nil, errors.New(strings.TrimSpace(string(output)))
+	}
+	return parsePromText(string(output)), nil
+}
+
+// parsePromText() parses the Prometheus text format. It ignores comments (HELP and TYPE).
+func parsePromText(text string) []promSeries {
+	series := []promSeries{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		s := promSeries{name: line[:i], value: value}
+		if j := strings.IndexByte(s.name, '{'); j >= 0 {
+			s.labels = strings.TrimSuffix(s.name[j+1:], "}")
+			s.name = s.name[:j]
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// selectGokiMetrics() returns the series of the names. If names is empty, it returns all series.
+// For histograms, it returns _count, _sum, and the quantiles instead of _bucket.
+// The quantiles are calculated from the increase since prev, if prev has the histogram.
+func selectGokiMetrics(series []promSeries, prev []promSeries, names []string) []promSeries {
+	selected := []promSeries{}
+	if len(names) == 0 {
+		// Replace the buckets of each histogram with the quantiles at the position of its first bucket.
+		done := map[string]bool{}
+		for _, s := range series {
+			if !strings.HasSuffix(s.name, "_bucket") {
+				selected = append(selected, s)
+				continue
+			}
+			name := strings.TrimSuffix(s.name, "_bucket")
+			if !done[name] {
+				done[name] = true
+				selected = append(selected, gokiHistogramQuantiles(name, series, prev)...)
+			}
+		}
+		return selected
+	}
+
+	for _, name := range names {
+		for _, s := range series {
+			switch s.name {
+			case name, name + "_count", name + "_sum":
+				selected = append(selected, s)
+			}
+		}
+		selected = append(selected, gokiHistogramQuantiles(name, series, prev)...)
+	}
+	return selected
+}
+
+// gokiHistogramQuantiles() returns the quantiles of the histogram of the name for each set of labels.
+// It returns nothing if the name is not a histogram.
+func gokiHistogramQuantiles(name string, series []promSeries, prev []promSeries) []promSeries {
+	buckets := map[string][]promSeries{}
+	for _, s := range series {
+		if s.name == name+"_bucket" {
+			labels, _ := splitLeLabel(s.labels)
+			buckets[labels] = append(buckets[labels], s)
+		}
+	}
+
+	prevBuckets := map[string]float64{}
+	for _, s := range prev {
+		if s.name == name+"_bucket" {
+			prevBuckets[s.labels] = s.value
+		}
+	}
+
+	keys := []string{}
+	for labels := range buckets {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+
+	quantiles := []promSeries{}
+	for _, labels := range keys {
+		for _, q := range gokiMetricsQuantiles {
+			if v, ok := histogramQuantile(q, buckets[labels], prevBuckets); ok {
+				quantiles = append(quantiles, promSeries{
+					name:   name + "_p" + strconv.FormatFloat(q*100, 'g', -1, 64),
+					labels: labels,
+					value:  v,
+				})
+			}
+		}
+	}
+	return quantiles
+}
+
+// splitLeLabel() returns the labels without "le", and the value of "le".
+func splitLeLabel(labels string) (string, float64) {
+	others := []string{}
+	le := math.Inf(1)
+	for _, l := range strings.Split(labels, ",") {
+		if strings.HasPrefix(l, "le=") {
+			le, _ = strconv.ParseFloat(strings.Trim(strings.TrimPrefix(l, "le="), `"`), 64)
+		} else if l != "" {
+			others = append(others, l)
+		}
+	}
+	return strings.Join(others, ","), le
+}
+
+// histogramQuantile() calculates the quantile from the cumulative buckets like histogram_quantile() of PromQL.
+// If the buckets increased since prev, it uses the increase. It returns false if there is no observation.
+func histogramQuantile(q float64, buckets []promSeries, prev map[string]float64) (float64, bool) {
+	type bucket struct{ le, count float64 }
+	bs := []bucket{}
+	for _, b := range buckets {
+		_, le := splitLeLabel(b.labels)
+		count := b.value
+		if p, ok := prev[b.labels]; ok && p <= count {
+			count -= p
+		}
+		bs = append(bs, bucket{le, count})
+	}
+	if len(bs) == 0 {
+		return 0, false
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].le < bs[j].le })
+
+	total := bs[len(bs)-1].count
+	if total == 0 {
+		if len(prev) != 0 {
+			// No observation since prev. Use all observations since the node started.
+			return histogramQuantile(q, buckets, nil)
+		}
+		return 0, false
+	}
+
+	rank := q * total
+	lower, lowerCount := 0.0, 0.0
+	for _, b := range bs {
+		if b.count >= rank {
+			if math.IsInf(b.le, 1) {
+				return lower, true
+			}
+			if b.count == lowerCount {
+				return b.le, true
+			}
+			return lower + (b.le-lower)*(rank-lowerCount)/(b.count-lowerCount), true
+		}
+		lower, lowerCount = b.le, b.count
+	}
+	return lower, true
+}
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.AddCommand(metricsRecordCmd)
+	metricsCmd.AddCommand(metricsSnapshotCmd)
+	// Flags of goki metrics record and snapshot.
+	for _, c := range []*cobra.Command{metricsRecordCmd, metricsSnapshotCmd} {
+		c.Flags().StringVar(&metricsCmdFlags.metrics, "metrics", "", "Comma separated metric names in Prometheus format (e.g. sql_txn_latency,ranges_underreplicated). Default is all metrics.")
+		c.Flags().StringVarP(&metricsCmdFlags.output, "output", "o", "", "Path of output file. Default is stdout.")
+		c.Flags().StringVar(&metricsCmdFlags.format, "format", "", "Output format (csv or json). Default is json if the output file ends with .json, otherwise csv.")
+	}
+	metricsRecordCmd.Flags().DurationVar(&metricsCmdFlags.duration, "duration", time.Minute, "Duration of recording.")
+	metricsRecordCmd.Flags().DurationVar(&metricsCmdFlags.interval, "interval", 10*time.Second, "Interval of polling.")
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectGokiMetrics(t *testing.T) {
+	series := parsePromText(`# TYPE sql_conns gauge
+sql_conns 3
+# TYPE sql_txn_latency histogram
+sql_txn_latency_bucket{le="10"} 50
+sql_txn_latency_bucket{le="20"} 100
+sql_txn_latency_bucket{le="+Inf"} 100
+sql_txn_latency_sum 1000
+sql_txn_latency_count 100
+ranges_underreplicated{store="1"} 0
+`)
+
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, []string{"sql_conns", "sql_txn_latency_p50", "sql_txn_latency_p90", "sql_txn_latency_p99", "sql_txn_latency_sum", "sql_txn_latency_count", "ranges_underreplicated"}},
+		{[]string{"sql_txn_latency"}, []string{"sql_txn_latency_sum", "sql_txn_latency_count", "sql_txn_latency_p50", "sql_txn_latency_p90", "sql_txn_latency_p99"}},
+		{[]string{"ranges_underreplicated", "sql_conns"}, []string{"ranges_underreplicated", "sql_conns"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, s := range selectGokiMetrics(series, nil, tt.names) {
+			got = append(got, s.name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectGokiMetrics(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to monitoring stack
+	gokiPrometheusImage string = "prom/prometheus:v2.51.2" // Prometheus's image name and tag in the Docker Hub.
+	gokiGrafanaImage    string = "grafana/grafana:10.4.2"  // Grafana's image name and tag in the Docker Hub.
+	gokiMonitoringIp    string = "127.0.0.1"               // IP that Prometheus and Grafana will listen.
+)
+
+// Flag value of monitoring up command.
+var monitoringUpCmdFlags struct {
+	prometheusPort int // Published port of Prometheus.
+	grafanaPort    int // Published port of Grafana.
+}
+
+// monitoringCmd represents the monitoring command
+var monitoringCmd = &cobra.Command{
+	Use:   "monitoring",
+	Short: "Manage the Prometheus and Grafana monitoring stack",
+	Long: `The "goki monitoring" command manages the monitoring stack (Prometheus and Grafana) attached to the CockroachDB Local Cluster.
+The containers of the monitoring stack are also deleted by "goki delete" command.`,
+}
+
+// monitoringUpCmd represents the monitoring up command
+var monitoringUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Launch Prometheus and Grafana",
+	Long: `The "goki monitoring up" command launches Prometheus that scrapes all nodes,
+and Grafana that has the CockroachDB dashboard.
+* By default, Prometheus listens on 127.0.0.1:9090, and Grafana listens on 127.0.0.1:3000.
+    goki monitoring up
+* You can specify the ports with --prometheus-port and --grafana-port flag.
+    goki monitoring up --prometheus-port 19090 --grafana-port 13000
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// The monitoring stack joins the network of the cluster.
+		if err := checkGokiNetworkExists(); err != nil {
+			return err
+		}
+
+		m, err := loadGokiMetadata()
+		if err != nil {
+			return err
+		}
+		ids, err := gokiNodeIds(m)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("INFO: *** Start Launching Monitoring Stack ***")
+
+		if err := createPrometheusContainer(ids); err != nil {
+			return err
+		}
+
+		if err := createGrafanaContainer(); err != nil {
+			// Do not leave Prometheus alone, as "goki create" rolls back the created resources.
+			runGokiCleanup(func() { removeGokiMonitoringContainers([]string{gokiResourceName + "-prometheus"}) })
+			return err
+		}
+
+		fmt.Println("*** Launching Monitoring Stack done ***")
+		fmt.Printf("\nPrometheus:\n  URL: http://%v:%v/\n", gokiMonitoringIp, monitoringUpCmdFlags.prometheusPort)
+		fmt.Printf("\nGrafana (User: admin / Password: admin):\n  URL: http://%v:%v/\n\n", gokiMonitoringIp, monitoringUpCmdFlags.grafanaPort)
+
+		return nil
+	},
+}
+
+// monitoringDownCmd represents the monitoring down command
+var monitoringDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Delete Prometheus and Grafana",
+	Long:  `The "goki monitoring down" command deletes the containers of Prometheus and Grafana.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		for _, container := range []string{gokiResourceName + "-prometheus", gokiResourceName + "-grafana"} {
+			c := gokiCommand("docker", "rm", "-f", container)
+			if output, err := c.CombinedOutput(); err != nil && strings.Contains(string(output), "No such container") {
+				fmt.Println("The container " + container + " does not exist.")
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "docker rm command failed: %v\n", string(output))
+				return err
+			} else {
+				fmt.Println("The container " + container + " was deleted.")
+			}
+		}
+
+		return nil
+	},
+}
+
+// removeGokiMonitoringContainers() removes the containers that "goki monitoring up" created, if it fails.
+// It continues even if removing some container fails.
+func removeGokiMonitoringContainers(containers []string) {
+	for _, container := range containers {
+		c := gokiCommand("docker", "rm", "-f", container)
+		if output, err := c.CombinedOutput(); err != nil {
+			if strings.Contains(string(output), "No such container") {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "ERROR: docker rm command that removing %v failed.\n Error is: %v\n", container, string(output))
+			continue
+		}
+		fmt.Println("INFO: Removed container is: " + container)
+	}
+}
+
+func checkGokiNetworkExists() error {
+	c := gokiCommand("docker", "network", "inspect", gokiResourceName+"-net")
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: The Goki Cluster is not running.")
+		fmt.Fprintf(os.Stderr, "HINT: Please create the cluster by \"goki create\" command.\n docker network inspect command failed: %v\n", string(output))
+		return err
+	}
+	return nil
+}
+
+func createPrometheusContainer(ids []int) error {
+	fmt.Println("INFO: Creating Prometheus container start.")
+
+	container := gokiResourceName + "-prometheus"
+	c := gokiCommand("docker", "create",
+		"--name="+container,
+		"--hostname="+container,
+		"--network="+gokiResourceName+"-net",
+		"-p", gokiMonitoringIp+":"+strconv.Itoa(monitoringUpCmdFlags.prometheusPort)+":9090",
+		"--mount=type=volume,src="+gokiResourceName+"-volume-client,dst=/cockroach/certs,readonly",
+		"--label="+gokiResourceLabel,
+		gokiPrometheusImage,
+		"--config.file=/etc/prometheus/goki/prometheus.yml",
+		"--storage.tsdb.path=/prometheus",
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker create command that creating %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+	// If the rest fails, remove the created container. Otherwise, "goki monitoring up" cannot create it again.
+	started := false
+	defer func() {
+		if !started {
+			runGokiCleanup(func() { removeGokiMonitoringContainers([]string{container}) })
+		}
+	}()
+
+	files := map[string][]byte{"prometheus.yml": []byte(prometheusConfig(ids))}
+	if err := copyFilesToContainer(container, "/etc/prometheus/goki", files); err != nil {
+		return err
+	}
+
+	if err := startContainer(container); err != nil {
+		return err
+	}
+
+	started = true
+	fmt.Println("INFO: Creating Prometheus container done.")
+	return nil
+}
+
+// prometheusConfig() returns the config of Prometheus that scrapes /_status/vars of all nodes via HTTPS.
+func prometheusConfig(ids []int) string {
+	targets := []string{}
+	for _, id := range ids {
+		targets = append(targets, strconv.Quote(gokiResourceName+"-"+strconv.Itoa(id)+":8080"))
+	}
+
+	return `global:
+  scrape_interval: 10s
+  evaluation_interval: 10s
+scrape_configs:
+  - job_name: cockroachdb
+    metrics_path: /_status/vars
+    scheme: https
+    tls_config:
+      ca_file: /cockroach/certs/ca.crt
+    static_configs:
+      - targets: [` + strings.Join(targets, ", ") + `]
+        labels:
+          cluster: ` + gokiResourceName + `
+    relabel_configs:
+      - source_labels: [__address__]
+        regex: (.+):8080
+        target_label: instance
+        replacement: $1
+`
+}
+
+func createGrafanaContainer() error {
+	fmt.Println("INFO: Creating Grafana container start.")
+
+	container := gokiResourceName + "-grafana"
+	c := gokiCommand("docker", "create",
+		"--name="+container,
+		"--hostname="+container,
+		"--network="+gokiResourceName+"-net",
+		"-p", gokiMonitoringIp+":"+strconv.Itoa(monitoringUpCmdFlags.grafanaPort)+":3000",
+		"--label="+gokiResourceLabel,
+		"-e", "GF_AUTH_ANONYMOUS_ENABLED=true",
+		"-e", "GF_AUTH_ANONYMOUS_ORG_ROLE=Viewer",
+		gokiGrafanaImage,
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker create command that creating %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+	// If the rest fails, remove the created container. Otherwise, "goki monitoring up" cannot create it again.
+	started := false
+	defer func() {
+		if !started {
+			runGokiCleanup(func() { removeGokiMonitoringContainers([]string{container}) })
+		}
+	}()
+
+	dashboard, err := grafanaDashboard()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Encoding Grafana dashboard failed.\n Error is: %v\n", err)
+		return err
+	}
+	files := map[string][]byte{
+		"datasources/goki.yml": []byte(`apiVersion: 1
+datasources:
+  - name: Prometheus
+    type: prometheus
+    access: proxy
+    url: http://` + gokiResourceName + `-prometheus:9090
+    isDefault: true
+`),
+		"dashboards/goki.yml": []byte(`apiVersion: 1
+providers:
+  - name: goki
+    folder: CockroachDB
+    type: file
+    options:
+      path: /etc/grafana/provisioning/dashboards/cockroachdb
+`),
+		"dashboards/cockroachdb/overview.json": dashboard,
+	}
+	if err := copyFilesToContainer(container, "/etc/grafana/provisioning", files); err != nil {
+		return err
+	}
+
+	if err := startContainer(container); err != nil {
+		return err
+	}
+
+	started = true
+	fmt.Println("INFO: Creating Grafana container done.")
+	return nil
+}
+
+// Panels of the CockroachDB dashboard. Each panel has the title, unit, and PromQL queries.
+var grafanaPanels = []struct {
+	title string
+	unit  string
+	exprs []string
+}{
+	{"SQL Queries", "reqps", []string{`sum by (instance) (rate(sql_query_count[1m]))`}},
+	{"Service Latency: SQL, 99th percentile", "ns", []string{`histogram_quantile(0.99, sum by (le, instance) (rate(sql_service_latency_bucket[1m])))`}},
+	{"Live Node Count", "short", []string{`max(liveness_livenodes)`}},
+	{"Replication Health", "short", []string{`sum(ranges_underreplicated)`, `sum(ranges_unavailable)`, `sum(ranges_overreplicated)`}},
+	{"Replicas per Node", "short", []string{`sum by (instance) (replicas)`}},
+	{"Leaseholders per Node", "short", []string{`sum by (instance) (replicas_leaseholders)`}},
+	{"CPU Percent", "percentunit", []string{`sum by (instance) (sys_cpu_combined_percent_normalized)`}},
+	{"Capacity Used", "percentunit", []string{`sum by (instance) (capacity_used) / sum by (instance) (capacity)`}},
+	{"SQL Connections", "short", []string{`sum by (instance) (sql_conns)`}},
+	{"Transaction Restarts", "short", []string{`sum by (instance) (rate(txn_restarts[1m]))`}},
+}
+
+// grafanaDashboard() returns the JSON of the CockroachDB dashboard.
+func grafanaDashboard() ([]byte, error) {
+	panels := []map[string]interface{}{}
+	for i, p := range grafanaPanels {
+		targets := []map[string]interface{}{}
+		for j, expr := range p.exprs {
+			targets = append(targets, map[string]interface{}{
+				"refId":        string(rune('A' + j)),
+				"expr":         expr,
+				"legendFormat": "{{instance}}",
+			})
+		}
+		panels = append(panels, map[string]interface{}{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       p.title,
+			"datasource":  "Prometheus",
+			"gridPos":     map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]interface{}{"defaults": map[string]string{"unit": p.unit}},
+			"targets":     targets,
+		})
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"uid":           gokiResourceName + "-overview",
+		"title":         "CockroachDB Overview (" + gokiResourceName + ")",
+		"schemaVersion": 39,
+		"refresh":       "10s",
+		"time":          map[string]string{"from": "now-30m", "to": "now"},
+		"panels":        panels,
+	}, "", "  ")
+}
+
+func init() {
+	rootCmd.AddCommand(monitoringCmd)
+	monitoringCmd.AddCommand(monitoringUpCmd)
+	monitoringCmd.AddCommand(monitoringDownCmd)
+	// Flags of goki monitoring up.
+	monitoringUpCmd.Flags().IntVar(&monitoringUpCmdFlags.prometheusPort, "prometheus-port", 9090, "Published port of Prometheus.")
+	monitoringUpCmd.Flags().IntVar(&monitoringUpCmdFlags.grafanaPort, "grafana-port", 3000, "Published port of Grafana.")
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Output formats of the commands that support --output (-o) flag.
+	gokiOutputTable string = "table"
+	gokiOutputJson  string = "json"
+	gokiOutputYaml  string = "yaml"
+	// ANSI escape codes of colors.
+	gokiColorRed    string = "\033[31m"
+	gokiColorGreen  string = "\033[32m"
+	gokiColorYellow string = "\033[33m"
+	gokiColorReset  string = "\033[0m"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case gokiOutputTable, gokiOutputJson, gokiOutputYaml:
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify the output format table, json, or yaml.")
+	return errors.New("invalid argument. Please specify the output format table, json, or yaml")
+}
+
+func colorize(color string, s string) string {
+	return color + s + gokiColorReset
+}
+
+// writeStructured() writes v as JSON or YAML. The keys are the "json" tags of struct fields.
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case gokiOutputJson:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	case gokiOutputYaml:
+		var b strings.Builder
+		writeYaml(&b, reflect.ValueOf(v), 0)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return errors.New("unsupported output format: " + format)
+}
+
+// writeYaml() is a minimal YAML encoder for the structs, slices, and scalars that Goki outputs.
+// Strings are written as double-quoted scalars, which have the same escape rules as JSON.
+func writeYaml(b *strings.Builder, v reflect.Value, indent int) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			b.WriteString(" null\n")
+			return
+		}
+		v = v.Elem()
+	}
+	pad := strings.Repeat("  ", indent)
+
+	switch {
+	case v.Type() == reflect.TypeOf(time.Time{}):
+		b.WriteString(" " + strconv.Quote(v.Interface().(time.Time).Format(time.RFC3339)) + "\n")
+	case v.Kind() == reflect.Struct:
+		if indent > 0 {
+			b.WriteString("\n")
+		}
+		for i := 0; i < v.NumField(); i++ {
+			name, omitEmpty := yamlFieldName(v.Type().Field(i))
+			if name == "" || (omitEmpty && v.Field(i).IsZero()) {
+				continue
+			}
+			b.WriteString(pad + name + ":")
+			writeYaml(b, v.Field(i), indent+1)
+		}
+	case v.Kind() == reflect.Slice:
+		if v.Len() == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		if indent > 0 {
+			b.WriteString("\n")
+		}
+		for i := 0; i < v.Len(); i++ {
+			var e strings.Builder
+			writeYaml(&e, v.Index(i), indent+1)
+			if s := e.String(); strings.HasPrefix(s, "\n") {
+				// The element is a struct. Write its first field after "- ".
+				b.WriteString(pad + "- " + strings.TrimPrefix(s[1:], pad+"  "))
+			} else {
+				b.WriteString(pad + "-" + s)
+			}
+		}
+	case v.Kind() == reflect.String:
+		b.WriteString(" " + strconv.Quote(v.String()) + "\n")
+	default:
+		b.WriteString(" " + fmt.Sprint(v.Interface()) + "\n")
+	}
+}
+
+func yamlFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" || f.PkgPath != "" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, p := range parts[1:] {
+		if p == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
@@ -0,0 +1,500 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to proxy
+	gokiProxyListenAddr string = "127.0.0.1:26100" // Address that the proxy listens for SQL connection.
+	gokiProxyApiAddr    string = "127.0.0.1:8474"  // Address of the API that controls the running proxy.
+	gokiProxyBufferSize int    = 32 * 1024         // Size of the buffer that the proxy reads at once.
+	gokiProxyQueueSize  int    = 64                // Number of chunks that each direction of a connection holds while the latency toxic delays them.
+)
+
+// Flag value of proxy command (common in subcommands).
+var proxyCmdFlags struct {
+	api string // Address of the API of the proxy.
+}
+
+// Flag value of proxy up command.
+var proxyUpCmdFlags struct {
+	listen   string // Address that the proxy listens.
+	upstream string // Address of the upstream (SQL port of the cluster).
+}
+
+// Flag value of proxy toxic add command.
+var proxyToxicAddCmdFlags struct {
+	name      string        // Name of the toxic.
+	direction string        // Direction of the toxic.
+	jitter    time.Duration // Jitter of latency toxic.
+}
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Fault-injecting TCP proxy in front of the SQL port",
+	Long: `The "goki proxy" command runs the TCP proxy between host applications and the cluster,
+and injects faults (toxics) into the connections at runtime. It is useful to test retry logic of applications.`,
+}
+
+// proxyUpCmd represents the proxy up command
+var proxyUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Run the proxy",
+	Long: `The "goki proxy up" command runs the proxy until Ctrl-C or "goki proxy down".
+Please run "goki proxy toxic" commands in another terminal to control the proxy.
+* By default, the proxy listens on 127.0.0.1:26100, and forwards connections to the load balancer if it exists, or the first node.
+    goki proxy up
+* You can specify the addresses with --listen and --upstream flag.
+    goki proxy up --listen 127.0.0.1:36257 --upstream 127.0.0.1:26000
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		m, err := loadGokiMetadata()
+		if err != nil {
+			return err
+		} else if m != nil {
+			gokiCred = m.Credential
+		}
+
+		upstream := proxyUpCmdFlags.upstream
+		if upstream == "" {
+			upstream = gokiSqlIp + ":" + gokiSqlPort
+			if m != nil && m.LoadBalancer != nil {
+				upstream = m.LoadBalancer.SqlAddr
+			}
+		}
+
+		if err := runGokiProxy(proxyUpCmdFlags.listen, upstream, proxyCmdFlags.api); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// proxyDownCmd represents the proxy down command
+var proxyDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Stop the running proxy",
+	Long:  `The "goki proxy down" command stops the running proxy and closes all connections via the proxy.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := gokiProxyRequest(http.MethodPost, "/shutdown", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("INFO: The proxy was stopped.")
+
+		return nil
+	},
+}
+
+// proxyToxicCmd represents the proxy toxic command
+var proxyToxicCmd = &cobra.Command{
+	Use:   "toxic",
+	Short: "Manage toxics of the running proxy",
+	Long: `The "goki proxy toxic" command adds, removes, and lists toxics of the running proxy.
+Toxics are applied to the existing connections and new connections immediately.`,
+}
+
+// proxyToxicAddCmd represents the proxy toxic add command
+var proxyToxicAddCmd = &cobra.Command{
+	Use:   "add <type> [value]",
+	Short: "Add a toxic to the running proxy",
+	Long: `The "goki proxy toxic add" command adds a toxic to the running proxy. The following types are available.
+  * latency <delay>      : Delay the data. You can add random variation with --jitter flag.
+  * bandwidth <rate>     : Limit the bandwidth to the rate per second (e.g. 100kb, 1mb).
+  * reset [timeout]      : Reset the connections (TCP RST) after the timeout (default is immediately).
+  * timeout [timeout]    : Drop the data (half-open connection), and close the connections after the timeout (default is never).
+* By default, the name of the toxic is the type, and the toxic is applied to both directions.
+    goki proxy toxic add latency 200ms
+* You can specify the name and the direction (upstream, downstream, or both) with --name and --direction flag.
+    goki proxy toxic add bandwidth 10kb --name slow-download --direction downstream
+`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		value := ""
+		if len(args) == 2 {
+			value = args[1]
+		}
+		t, err := newGokiToxic(args[0], value, proxyToxicAddCmdFlags.name, proxyToxicAddCmdFlags.direction, proxyToxicAddCmdFlags.jitter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. %v.\n", err)
+			return err
+		}
+
+		if err := gokiProxyRequest(http.MethodPost, "/toxics", t, nil); err != nil {
+			return err
+		}
+		fmt.Printf("INFO: The toxic %v (%v %v, %v) was added.\n", t.Name, t.Type, t.attributes(), t.Direction)
+
+		return nil
+	},
+}
+
+// proxyToxicRmCmd represents the proxy toxic rm command
+var proxyToxicRmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Remove toxics from the running proxy",
+	Long: `The "goki proxy toxic rm" command removes the toxics from the running proxy.
+    goki proxy toxic rm latency
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		for _, name := range args {
+			if err := gokiProxyRequest(http.MethodDelete, "/toxics/"+name, nil, nil); err != nil {
+				return err
+			}
+			fmt.Printf("INFO: The toxic %v was removed.\n", name)
+		}
+
+		return nil
+	},
+}
+
+// proxyToxicResetCmd represents the proxy toxic reset command
+var proxyToxicResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Remove all toxics from the running proxy",
+	Long:  `The "goki proxy toxic reset" command removes all toxics from the running proxy.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := gokiProxyRequest(http.MethodDelete, "/toxics", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("INFO: All toxics were removed.")
+
+		return nil
+	},
+}
+
+// proxyToxicLsCmd represents the proxy toxic ls command
+var proxyToxicLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List toxics of the running proxy",
+	Long:  `The "goki proxy toxic ls" command shows the proxy and its toxics.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		var s gokiProxyStatus
+		if err := gokiProxyRequest(http.MethodGet, "/proxy", nil, &s); err != nil {
+			return err
+		}
+
+		fmt.Printf("Proxy: %v -> %v (%d connections)\n\n", s.Listen, s.Upstream, s.Connections)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTYPE\tDIRECTION\tATTRIBUTES")
+		for _, t := range s.Toxics {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", t.Name, t.Type, t.Direction, t.attributes())
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+// Fault-injecting TCP proxy.
+type gokiProxy struct {
+	listen   string
+	upstream string
+	mu       sync.RWMutex
+	toxics   []gokiToxic
+	nextId   int
+	conns    map[*gokiProxyConn]struct{}
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+// Status of the proxy that the API returns.
+type gokiProxyStatus struct {
+	Listen      string      `json:"listen"`
+	Upstream    string      `json:"upstream"`
+	Connections int         `json:"connections"`
+	Toxics      []gokiToxic `json:"toxics"`
+}
+
+func runGokiProxy(listen string, upstream string, api string) error {
+	p := &gokiProxy{
+		listen:   listen,
+		upstream: upstream,
+		conns:    map[*gokiProxyConn]struct{}{},
+		shutdown: make(chan struct{}),
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Listening on %v failed.\n Error is: %v\n", listen, err)
+		return err
+	}
+	defer l.Close()
+
+	al, err := net.Listen("tcp", api)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Listening on %v failed.\n Error is: %v\n", api, err)
+		fmt.Fprintln(os.Stderr, "HINT: Maybe another proxy is already running. You can specify the address of the API with --api flag.")
+		return err
+	}
+	server := &http.Server{Handler: p}
+	go server.Serve(al)
+	defer server.Close()
+
+	go p.accept(l)
+
+	fmt.Printf("INFO: The proxy is listening on %v, and forwarding connections to %v.\n", listen, upstream)
+	fmt.Printf("INFO: The API is listening on %v. Press Ctrl-C to stop the proxy.\n", api)
+	fmt.Printf("\nAccess DB via the proxy as a non-root user:\n")
+	fmt.Printf("  postgresql://%v:<password>@%v/defaultdb?sslmode=require\n\n", gokiCred.NonRootUserName, listen)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case <-sig:
+	case <-p.shutdown:
+	}
+
+	p.mu.Lock()
+	for c := range p.conns {
+		c.close(false)
+	}
+	p.mu.Unlock()
+	fmt.Println("INFO: The proxy was stopped.")
+	return nil
+}
+
+func (p *gokiProxy) accept(l net.Listener) {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(client)
+	}
+}
+
+func (p *gokiProxy) handle(client net.Conn) {
+	upstream, err := net.DialTimeout("tcp", p.upstream, 10*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Connecting to %v failed.\n Error is: %v\n", p.upstream, err)
+		client.Close()
+		return
+	}
+	c := &gokiProxyConn{client: client, upstream: upstream}
+
+	p.mu.Lock()
+	p.conns[c] = struct{}{}
+	for _, t := range p.toxics {
+		p.scheduleClose(c, t)
+	}
+	p.mu.Unlock()
+
+	go p.pipe(c, upstream, client, gokiToxicUpstream)
+	p.pipe(c, client, upstream, gokiToxicDownstream)
+
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+}
+
+// scheduleClose() closes the connection after the timeout of reset and timeout toxic, if the toxic still exists.
+// The caller must hold p.mu.
+func (p *gokiProxy) scheduleClose(c *gokiProxyConn, t gokiToxic) {
+	if t.Type == gokiToxicTimeout && t.Timeout == 0 {
+		return
+	} else if t.Type != gokiToxicReset && t.Type != gokiToxicTimeout {
+		return
+	}
+
+	time.AfterFunc(t.Timeout, func() {
+		p.mu.RLock()
+		exists := false
+		for _, cur := range p.toxics {
+			exists = exists || cur.id == t.id
+		}
+		p.mu.RUnlock()
+		if exists {
+			c.close(t.Type == gokiToxicReset)
+		}
+	})
+}
+
+// toxicsOf() returns the current toxics of the direction.
+func (p *gokiProxy) toxicsOf(direction string) []gokiToxic {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	toxics := []gokiToxic{}
+	for _, t := range p.toxics {
+		if t.appliesTo(direction) {
+			toxics = append(toxics, t)
+		}
+	}
+	return toxics
+}
+
+func (p *gokiProxy) addToxic(t gokiToxic) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, cur := range p.toxics {
+		if cur.Name == t.Name {
+			return errors.New("the toxic " + t.Name + " already exists")
+		}
+	}
+	p.nextId++
+	t.id = p.nextId
+	p.toxics = append(p.toxics, t)
+
+	for c := range p.conns {
+		p.scheduleClose(c, t)
+	}
+	return nil
+}
+
+func (p *gokiProxy) removeToxic(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, t := range p.toxics {
+		if t.Name == name {
+			p.toxics = append(p.toxics[:i], p.toxics[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("the toxic " + name + " does not exist")
+}
+
+// ServeHTTP() is the API of the proxy that "goki proxy" commands call.
+func (p *gokiProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/proxy":
+		p.mu.RLock()
+		s := gokiProxyStatus{Listen: p.listen, Upstream: p.upstream, Connections: len(p.conns), Toxics: append([]gokiToxic{}, p.toxics...)}
+		p.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	case r.Method == http.MethodPost && r.URL.Path == "/toxics":
+		var t gokiToxic
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := t.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.addToxic(t); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete && r.URL.Path == "/toxics":
+		p.mu.Lock()
+		p.toxics = nil
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/toxics/"):
+		if err := p.removeToxic(strings.TrimPrefix(r.URL.Path, "/toxics/")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/shutdown":
+		w.WriteHeader(http.StatusNoContent)
+		p.once.Do(func() { close(p.shutdown) })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// gokiProxyRequest() calls the API of the running proxy. If out is not nil, the response is decoded into it.
+func gokiProxyRequest(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Encoding the request to the proxy failed.\n Error is: %v\n", err)
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, "http://"+proxyCmdFlags.api+path, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating the request to the proxy failed.\n Error is: %v\n", err)
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: The proxy is not running.")
+		fmt.Fprintf(os.Stderr, "HINT: Please run the proxy by \"goki proxy up\" command.\n Error is: %v\n", err)
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(res.Body)
+		fmt.Fprintf(os.Stderr, "ERROR: The proxy returned an error: %v\n", strings.TrimSpace(string(msg)))
+		return errors.New(strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Decoding the response from the proxy failed.\n Error is: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+	proxyCmd.AddCommand(proxyUpCmd)
+	proxyCmd.AddCommand(proxyDownCmd)
+	proxyCmd.AddCommand(proxyToxicCmd)
+	proxyToxicCmd.AddCommand(proxyToxicAddCmd)
+	proxyToxicCmd.AddCommand(proxyToxicRmCmd)
+	proxyToxicCmd.AddCommand(proxyToxicResetCmd)
+	proxyToxicCmd.AddCommand(proxyToxicLsCmd)
+	// Flags of goki proxy (common in subcommands).
+	proxyCmd.PersistentFlags().StringVar(&proxyCmdFlags.api, "api", gokiProxyApiAddr, "Address of the API that controls the running proxy.")
+	// Flags of goki proxy up.
+	proxyUpCmd.Flags().StringVar(&proxyUpCmdFlags.listen, "listen", gokiProxyListenAddr, "Address that the proxy listens for SQL connection.")
+	proxyUpCmd.Flags().StringVar(&proxyUpCmdFlags.upstream, "upstream", "", "Address that the proxy forwards connections to. (default: the load balancer or the first node)")
+	// Flags of goki proxy toxic add.
+	proxyToxicAddCmd.Flags().StringVar(&proxyToxicAddCmdFlags.name, "name", "", "Name of the toxic. (default: the type)")
+	proxyToxicAddCmd.Flags().StringVar(&proxyToxicAddCmdFlags.direction, "direction", gokiToxicBoth, "Direction of the toxic (upstream, downstream, or both).")
+	proxyToxicAddCmd.Flags().DurationVar(&proxyToxicAddCmdFlags.jitter, "jitter", 0, "Random variation of latency toxic.")
+}
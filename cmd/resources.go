@@ -0,0 +1,277 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Format of the memory limit that docker accepts (e.g. 512m, 1g, 1.5GiB).
+var gokiMemoryRegexp = regexp.MustCompile(`^\d+(\.\d+)?\s?([kKmMgGtT][iI]?)?[bB]?$`)
+
+// Flag value of resources set command.
+var resourcesSetCmdFlags struct {
+	gokiId int    // Number of node (container).
+	all    bool   // If true, update all nodes.
+	cpus   string // Number of CPUs.
+	memory string // Memory limit.
+}
+
+// Limits of CPU and memory of a container that "docker inspect" shows.
+type gokiResourceLimit struct {
+	cpus   float64 // Number of CPUs. 0 means unlimited.
+	memory int64   // Memory limit in bytes. 0 means unlimited.
+}
+
+// resourcesCmd represents the resources command
+var resourcesCmd = &cobra.Command{
+	Use:   "resources",
+	Short: "Manage CPU and memory limits of nodes",
+	Long: `The "goki resources" command manages CPU and memory limits of the running nodes.
+You can also set the limits when creating the cluster with --cpus and --memory flag of "goki create".
+"goki status" shows the current limits of each node.`,
+}
+
+// resourcesSetCmd represents the resources set command
+var resourcesSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Update CPU and memory limits of nodes",
+	Long: `The "goki resources set" command updates CPU and memory limits of the node without restarting it.
+* By default, it updates Node 1. You can specify the Node ID with -g (--goki) flag, or all nodes with --all flag.
+    goki resources set -g 3 --cpus 0.5 --memory 1g
+    goki resources set --all --cpus 2
+* Memory limit disables the swap of the node.
+* --cpus 0 removes the CPU limit. "docker update" cannot remove it, so Goki re-creates the node from the metadata.
+  The data in the volumes is kept. With --all flag, it re-creates the nodes one at a time.
+    goki resources set --all --cpus 0
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if !cmd.Flags().Changed("cpus") && !cmd.Flags().Changed("memory") {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. Please specify --cpus flag or --memory flag.")
+			return errors.New("invalid argument. Please specify --cpus flag or --memory flag")
+		}
+		if resourcesSetCmdFlags.all && cmd.Flags().Changed("goki") {
+			fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The -g (--goki) flag cannot be used with --all flag.")
+			return errors.New("invalid argument. The -g (--goki) flag cannot be used with --all flag")
+		}
+		if err := checkGokiResourceLimit(resourcesSetCmdFlags.cpus, resourcesSetCmdFlags.memory, true); err != nil {
+			return err
+		}
+
+		m, err := loadGokiMetadata()
+		if err != nil {
+			return err
+		}
+		ids := []int{resourcesSetCmdFlags.gokiId}
+		if resourcesSetCmdFlags.all {
+			if ids, err = gokiNodeIds(m); err != nil {
+				return err
+			}
+		} else if err := checkGokiNodeId(m, resourcesSetCmdFlags.gokiId); err != nil {
+			return err
+		}
+
+		if n, _ := strconv.ParseFloat(resourcesSetCmdFlags.cpus, 64); resourcesSetCmdFlags.cpus != "" && n == 0 {
+			if err := removeGokiCpuLimit(m, ids, resourcesSetCmdFlags.memory); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		for _, id := range ids {
+			if err := updateGokiResourceLimit(id, resourcesSetCmdFlags.cpus, resourcesSetCmdFlags.memory); err != nil {
+				return err
+			}
+		}
+
+		// Store the limits in the metadata, so that "goki clone" keeps them.
+		if m != nil {
+			for i := range m.Nodes {
+				if !resourcesSetCmdFlags.all && m.Nodes[i].Id != resourcesSetCmdFlags.gokiId {
+					continue
+				}
+				if resourcesSetCmdFlags.cpus != "" {
+					m.Nodes[i].Cpus = resourcesSetCmdFlags.cpus
+				}
+				if resourcesSetCmdFlags.memory != "" {
+					m.Nodes[i].Memory = resourcesSetCmdFlags.memory
+				}
+			}
+			if err := saveGokiMetadata(m); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+// checkGokiResourceLimit() checks the values of CPU and memory limits. The empty value means that it is not specified.
+// If zero is true, --cpus 0 (unlimited) is allowed.
+func checkGokiResourceLimit(cpus string, memory string, zero bool) error {
+	if cpus != "" {
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n < 0 || (n == 0 && !zero) {
+			fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The number of CPUs %v is not a positive number (e.g. 0.5).\n", cpus)
+			return errors.New("invalid argument. The number of CPUs is invalid")
+		}
+	}
+	if memory != "" && !gokiMemoryRegexp.MatchString(memory) {
+		fmt.Fprintf(os.Stderr, "ERROR: Invalid argument. The memory limit %v is not a size (e.g. 512m, 1g).\n", memory)
+		return errors.New("invalid argument. The memory limit is invalid")
+	}
+	return nil
+}
+
+// gokiResourceLimitArgs() returns the options of "docker run" and "docker update" that set CPU and memory limits.
+// Goki sets the same value to --memory-swap, so that the node does not swap (as in production).
+func gokiResourceLimitArgs(cpus string, memory string) []string {
+	args := []string{}
+	if cpus != "" {
+		args = append(args, "--cpus="+cpus)
+	}
+	if memory != "" {
+		args = append(args, "--memory="+memory, "--memory-swap="+memory)
+	}
+	return args
+}
+
+func updateGokiResourceLimit(id int, cpus string, memory string) error {
+	container := gokiResourceName + "-" + strconv.Itoa(id)
+
+	args := append([]string{"update"}, gokiResourceLimitArgs(cpus, memory)...)
+	c := gokiCommand("docker", append(args, container)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker update command that updating %v failed.\n Error is: %v\n", container, string(output))
+		return err
+	}
+
+	limits := []string{}
+	if cpus != "" {
+		limits = append(limits, "cpus="+cpus)
+	}
+	if memory != "" {
+		limits = append(limits, "memory="+memory)
+	}
+	fmt.Println("INFO: Updated the resource limits of " + container + ": " + strings.Join(limits, ", "))
+	return nil
+}
+
+// removeGokiCpuLimit() re-creates the nodes without the CPU limit, because "docker update" ignores --cpus 0.
+// If memory is not empty, it also sets the memory limit.
+func removeGokiCpuLimit(m *gokiMetadata, ids []int, memory string) error {
+	if m == nil {
+		fmt.Fprintln(os.Stderr, "ERROR: There is no metadata of the Goki Cluster. Removing the CPU limit requires it.")
+		fmt.Fprintln(os.Stderr, "HINT: Please specify the number of CPUs of the Docker host with --cpus flag instead.")
+		return errors.New("there is no metadata of the Goki Cluster")
+	}
+
+	for _, id := range ids {
+		n := &m.Nodes[id-1]
+		prev := *n
+		n.Cpus = ""
+		if memory != "" {
+			n.Memory = memory
+		}
+		// Re-create the next node after the ranges are fully replicated again, as in the rolling restart.
+		if len(ids) > 1 {
+			if err := waitGokiReplication(m); err != nil {
+				return err
+			}
+		}
+		if err := recreateGokiNode(m, prev, *n); err != nil {
+			return err
+		}
+		if err := saveGokiMetadata(m); err != nil {
+			return err
+		}
+		fmt.Println("INFO: Removed the CPU limit of " + n.Name + ".")
+	}
+	return nil
+}
+
+// getGokiResourceLimits() returns CPU and memory limits of the containers (key is container name).
+func getGokiResourceLimits(containers []string) (map[string]gokiResourceLimit, error) {
+	limits := map[string]gokiResourceLimit{}
+	if len(containers) == 0 {
+		return limits, nil
+	}
+
+	args := []string{"inspect", "--format", "{{.Name}}\t{{.HostConfig.NanoCpus}}\t{{.HostConfig.Memory}}"}
+	c := gokiCommand("docker", append(args, containers...)...)
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker inspect command failed.\n Error is: %v\n", string(output))
+		return nil, err
+	}
+
+	for _, line := range strings.FieldsFunc(string(output), func(r rune) bool { return r == '\n' }) {
+		f := strings.Split(line, "\t")
+		if len(f) != 3 {
+			continue
+		}
+		nanoCpus, _ := strconv.ParseInt(f[1], 10, 64)
+		memory, _ := strconv.ParseInt(f[2], 10, 64)
+		limits[strings.TrimPrefix(f[0], "/")] = gokiResourceLimit{cpus: float64(nanoCpus) / 1e9, memory: memory}
+	}
+	return limits, nil
+}
+
+// formatGokiCpus() formats the number of CPUs for the status table. 0 means unlimited.
+func formatGokiCpus(cpus float64) string {
+	if cpus == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
+
+// formatGokiMemoryLimit() formats the memory limit for the status table. 0 means unlimited.
+func formatGokiMemoryLimit(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return formatGokiMemory(n)
+}
+
+// formatGokiMemory() formats the bytes in binary units (e.g. 1.5GiB).
+func formatGokiMemory(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64) + units[i]
+}
+
+func init() {
+	rootCmd.AddCommand(resourcesCmd)
+	resourcesCmd.AddCommand(resourcesSetCmd)
+	// Flags of goki resources set.
+	resourcesSetCmd.Flags().IntVarP(&resourcesSetCmdFlags.gokiId, "goki", "g", 1, "The node ID of container that goki resources set command will update.")
+	resourcesSetCmd.Flags().BoolVar(&resourcesSetCmdFlags.all, "all", false, "Update all nodes.")
+	resourcesSetCmd.Flags().StringVar(&resourcesSetCmdFlags.cpus, "cpus", "", "Number of CPUs (e.g. 0.5). 0 removes the limit.")
+	resourcesSetCmd.Flags().StringVar(&resourcesSetCmdFlags.memory, "memory", "", "Memory limit (e.g. 512m, 1g).")
+}
@@ -18,8 +18,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -38,6 +38,8 @@ var reviveCmd = &cobra.Command{
     goki revive
 * You can specify the Node ID with -g (--goki) flag.
     goki revive -g 3
+* If the container of the node does not exist (e.g. re-creating the node failed), it re-creates the container from the metadata.
+  The data in the volumes is kept.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -51,6 +53,16 @@ var reviveCmd = &cobra.Command{
 
 // gokiRevive() is called in the "goki revive" and "goki start" command.
 func gokiRevive(id int) error {
+	// If the node was removed (e.g. re-creating it failed), re-create it from the metadata.
+	if missing, m, err := gokiNodeIsMissing(id); err != nil {
+		return err
+	} else if missing {
+		if err := recreateMissingGokiNode(m, m.Nodes[id-1]); err != nil {
+			return err
+		}
+		return nil
+	}
+
 	// Check the specified container is dead.
 	ok, err := gokiIsDead(id)
 	if err != nil {
@@ -73,7 +85,7 @@ func reviveGokiContainer(id int) error {
 	container := gokiResourceName + "-" + strconv.Itoa(id)
 
 	// Revive specified container using "docker start" command.
-	c := exec.Command("docker", "start", container)
+	c := gokiCommand("docker", "start", container)
 	if output, err := c.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "docker start command failed: %v\n", string(output))
 		return err
@@ -85,6 +97,39 @@ func reviveGokiContainer(id int) error {
 	return nil
 }
 
+// gokiNodeIsMissing() returns true and the metadata, if the node is in the metadata but its container does not exist.
+func gokiNodeIsMissing(id int) (bool, *gokiMetadata, error) {
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return false, nil, err
+	} else if m == nil || id < 1 || len(m.Nodes) < id {
+		return false, nil, nil
+	}
+
+	c := gokiCommand("docker", "ps", "-aq", "-f", "name=^"+m.Nodes[id-1].Name+"$")
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
+		return false, nil, err
+	}
+	return strings.TrimSpace(string(output)) == "", m, nil
+}
+
+// recreateMissingGokiNode() runs the node from the metadata. The data in the volumes is kept.
+func recreateMissingGokiNode(m *gokiMetadata, n gokiNodeMetadata) error {
+	fmt.Println("INFO: The container " + n.Name + " does not exist. Re-creating it from the metadata.")
+	c := gokiCommand("docker", gokiNodeRunArgs(m, n)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "docker run command failed: %v\n", string(output))
+		return err
+	}
+
+	// Show revived container.
+	fmt.Println("The container " + n.Name + " was revived.")
+
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(reviveCmd)
 	// Flags of goki revive.
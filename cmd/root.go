@@ -15,11 +15,25 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// Flag value of all commands.
+var rootCmdFlags struct {
+	timeout time.Duration // Timeout of the whole command. 0 means no timeout.
+}
+
+// Releases the timer of --timeout.
+var gokiTimeoutCancel context.CancelFunc = func() {}
+
 var rootCmd = &cobra.Command{
 	Use:   "goki",
 	Short: "Goki creates or deletes CockroachDB Local Cluster utilizes Docker",
@@ -28,14 +42,40 @@ Goki deploys the swarm of cockroaches on your local environment.
 
 Note: For test at your local or development environment. Not for production.`,
 	SilenceUsage: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if rootCmdFlags.timeout > 0 {
+			ctx, cancel := context.WithTimeout(gokiRootCtx, rootCmdFlags.timeout)
+			gokiRootCtx, gokiTimeoutCancel = ctx, cancel
+			setGokiContext(ctx)
+		}
+	},
 }
 
 func Execute() {
+	// Ctrl-C (SIGINT) or SIGTERM cancels the running docker commands and SQL queries, so that Goki can clean up in order.
+	// After that, another SIGINT or SIGTERM terminates Goki immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	gokiRootCtx = ctx
+	setGokiContext(ctx)
+
 	err := rootCmd.Execute()
+	gokiTimeoutCancel()
+	if err != nil && errors.Is(gokiRootCtx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(os.Stderr, "ERROR: goki timed out after %v (--timeout).\n", rootCmdFlags.timeout)
+	} else if err != nil && ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: goki was interrupted.")
+	}
+	// Record the action history for "goki debug bundle".
+	recordGokiHistory(os.Args[1:], err)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
+	rootCmd.PersistentFlags().DurationVar(&rootCmdFlags.timeout, "timeout", 0, "Timeout of the whole command (e.g. 10m). By default, no timeout.")
 }
@@ -0,0 +1,540 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Related to snapshot
+	gokiSnapshotLabel       string = "goki-snapshot" // Label of snapshot volumes. It is different from gokiResourceLabel, so "goki delete -v" keeps snapshots.
+	gokiSnapshotDir         string = "snapshots"     // Directory of snapshot metadata in the Goki config directory.
+	gokiSnapshotStopTimeout int    = 60              // Seconds to wait for nodes to shut down gracefully before killing them.
+)
+
+// Valid name of snapshots. It is used as a part of volume names.
+var gokiSnapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Metadata of a snapshot that Goki stores in the user's config directory.
+type gokiSnapshot struct {
+	Name      string        `json:"name"`               // Name of the snapshot.
+	CreatedAt time.Time     `json:"created_at"`         // Time when "goki snapshot save" created the snapshot.
+	Volumes   []string      `json:"volumes"`            // Names of the original volumes.
+	Metadata  *gokiMetadata `json:"metadata,omitempty"` // Cluster metadata at the time. It is nil if the cluster was created by old Goki.
+}
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore snapshots of the cluster data",
+	Long: `The "goki snapshot" command saves all Docker Volumes of the cluster (DB data and certs) as a snapshot,
+and restores them in seconds. It is useful to reset integration tests to a known state.
+Snapshots are copies of the volumes labeled "` + gokiSnapshotLabel + `". The "goki delete -v" command does not delete them.`,
+}
+
+// snapshotSaveCmd represents the snapshot save command
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <name>",
+	Short: "Save a snapshot of the cluster",
+	Long: `The "goki snapshot save" command stops all nodes, copies all volumes of the cluster, and starts the nodes again.
+Stopping all nodes before copying makes the snapshot consistent.
+    goki snapshot save fixtures-loaded
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiSnapshotName(args[0]); err != nil {
+			return err
+		}
+
+		if err := saveGokiSnapshot(args[0]); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// snapshotRestoreCmd represents the snapshot restore command
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Short: "Restore the cluster from a snapshot",
+	Long: `The "goki snapshot restore" command stops all nodes, overwrites all volumes of the cluster by the snapshot, and starts the nodes again.
+If the cluster does not exist, it re-creates the volumes only. Please run "goki create" after that.
+    goki snapshot restore fixtures-loaded
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if err := checkGokiSnapshotName(args[0]); err != nil {
+			return err
+		}
+
+		if err := restoreGokiSnapshot(args[0]); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+// snapshotLsCmd represents the snapshot ls command
+var snapshotLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List snapshots",
+	Long:  `The "goki snapshot ls" command shows all snapshots.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		snapshots, err := listGokiSnapshots()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCREATED\tNODES\tCRDB VERSION")
+		for _, s := range snapshots {
+			version := "-"
+			if s.Metadata != nil {
+				version = s.Metadata.CrdbVersion
+			}
+			fmt.Fprintf(w, "%v\t%v\t%d\t%v\n", s.Name, s.CreatedAt.Local().Format(time.RFC3339), len(gokiSnapshotNodeVolumes(s.Volumes)), version)
+		}
+		w.Flush()
+
+		return nil
+	},
+}
+
+// snapshotRmCmd represents the snapshot rm command
+var snapshotRmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Delete snapshots",
+	Long:  `The "goki snapshot rm" command deletes the snapshots and their volumes.`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		for _, name := range args {
+			if err := checkGokiSnapshotName(name); err != nil {
+				return err
+			}
+			if err := deleteGokiSnapshot(name); err != nil {
+				return err
+			}
+			fmt.Println("The snapshot " + name + " was deleted.")
+		}
+
+		return nil
+	},
+}
+
+func checkGokiSnapshotName(name string) error {
+	if !gokiSnapshotNameRegexp.MatchString(name) {
+		fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The name of snapshot must consist of alphanumeric characters, \"_\", \".\", and \"-\".")
+		return errors.New("invalid argument. Invalid name of snapshot")
+	}
+	return nil
+}
+
+// gokiSnapshotVolume() returns the name of the snapshot volume of the original volume (e.g. goki-volume-1 -> goki-snapshot-NAME-volume-1).
+func gokiSnapshotVolume(name string, volume string) string {
+	return gokiResourceName + "-snapshot-" + name + strings.TrimPrefix(volume, gokiResourceName)
+}
+
+// gokiSnapshotNodeVolumes() returns the volumes of the first store of nodes (goki-volume-<number>).
+// The volumes of the other stores (goki-volume-<number>-<number>) and the client volume are not included.
+func gokiSnapshotNodeVolumes(volumes []string) []string {
+	nodes := []string{}
+	for _, v := range volumes {
+		if _, err := strconv.Atoi(strings.TrimPrefix(v, gokiResourceName+"-volume-")); err == nil {
+			nodes = append(nodes, v)
+		}
+	}
+	return nodes
+}
+
+// gokiSnapshotStoreVolumes() returns the sorted volumes of all stores of nodes (goki-volume-<number> and goki-volume-<number>-<number>).
+// The client volume is not included.
+func gokiSnapshotStoreVolumes(volumes []string) []string {
+	stores := []string{}
+	for _, v := range volumes {
+		if v != gokiResourceName+"-volume-client" {
+			stores = append(stores, v)
+		}
+	}
+	sort.Strings(stores)
+	return stores
+}
+
+func gokiSnapshotPath(name string) (string, error) {
+	path, err := gokiMetadataPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), gokiSnapshotDir, name+".json"), nil
+}
+
+// getGokiVolumes() returns the names of volumes of the cluster (goki-volume-*).
+func getGokiVolumes() ([]string, error) {
+	c := gokiCommand("docker", "volume", "ls", "-f", "label="+gokiResourceLabel, "--format", "{{.Name}}")
+	output, err := c.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "docker volume ls command failed: %v\n", string(output))
+		return nil, err
+	}
+
+	volumes := []string{}
+	for _, v := range strings.Fields(string(output)) {
+		if strings.HasPrefix(v, gokiResourceName+"-volume-") {
+			volumes = append(volumes, v)
+		}
+	}
+	return volumes, nil
+}
+
+// copyGokiVolume() replaces all data in the dst volume with the data in the src volume.
+func copyGokiVolume(src string, dst string, label string) error {
+	c := gokiCommand("docker", "volume", "create", "--label="+label, dst)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker volume create command failed.\n Error is: %v\n", string(output))
+		return err
+	}
+
+	// Killing docker on an interruption or a timeout does not stop the container. So, remove it by its name.
+	helper := dst + "-copy-" + strconv.Itoa(os.Getpid())
+	defer runGokiCleanup(func() {
+		gokiCommand("docker", "rm", "-f", helper).Run()
+	})
+
+	c = gokiCommand("docker", "run", "--rm", "--init",
+		"--name="+helper,
+		"--network=none",
+		"--mount=type=volume,src="+src+",dst=/from,readonly",
+		"--mount=type=volume,src="+dst+",dst=/to",
+		"--label="+gokiHelperLabel,
+		"--entrypoint=sh",
+		gokiImage(),
+		"-c", "rm -rf /to/..?* /to/.[!.]* /to/* && cp -a /from/. /to/",
+	)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Copying volume %v to %v failed.\n Error is: %v\n", src, dst, string(output))
+		return err
+	}
+	fmt.Printf("INFO: Copied volume %v to %v.\n", src, dst)
+	return nil
+}
+
+// stopGokiNodes() stops the running nodes gracefully, and returns their names to start them again.
+func stopGokiNodes() ([]string, error) {
+	containers, err := getGokiContainers()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []string{}
+	for _, n := range nodesOfContainers(containers) {
+		if n.Container == "running" || n.Container == "paused" {
+			nodes = append(nodes, n.Name)
+		}
+	}
+	if len(nodes) == 0 {
+		return nodes, nil
+	}
+
+	fmt.Println("INFO: Stopping nodes: " + strings.Join(nodes, ", "))
+	// Stop all nodes at once. If nodes stop one by one, the remaining nodes lose the quorum and take a long time to drain.
+	c := gokiCommand("docker", append([]string{"stop", "-t", strconv.Itoa(gokiSnapshotStopTimeout)}, nodes...)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker stop command failed.\n Error is: %v\n", string(output))
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// startGokiNodes() starts the nodes, and waits until the cluster accepts SQL connections.
+func startGokiNodes(nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	fmt.Println("INFO: Starting nodes: " + strings.Join(nodes, ", "))
+	c := gokiCommand("docker", append([]string{"start"}, nodes...)...)
+	if output, err := c.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: docker start command failed.\n Error is: %v\n", string(output))
+		return err
+	}
+
+	if gokiContains(nodes, gokiResourceName+"-1") {
+		return checkSqlConnectionAcceptance()
+	}
+	return nil
+}
+
+func saveGokiSnapshot(name string) error {
+	path, err := gokiSnapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: The snapshot %v already exists.\n", name)
+		fmt.Fprintf(os.Stderr, "HINT: Please delete it by \"goki snapshot rm %v\" command, or use another name.\n", name)
+		return errors.New("the snapshot already exists")
+	}
+
+	volumes, err := getGokiVolumes()
+	if err != nil {
+		return err
+	} else if len(volumes) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: There is no volume of the Goki Cluster.")
+		fmt.Fprintln(os.Stderr, "HINT: Please create the cluster by \"goki create\" command.")
+		return errors.New("there is no volume of the Goki Cluster")
+	}
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return err
+	} else if m != nil && m.MemStore != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: The Goki Cluster uses in-memory stores. There is no data to save.")
+		fmt.Fprintln(os.Stderr, "HINT: Please use \"goki backup create\" command instead.")
+		return errors.New("the Goki Cluster uses in-memory stores")
+	}
+
+	fmt.Println("INFO: *** Start Saving Snapshot " + name + " ***")
+	nodes, err := stopGokiNodes()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range volumes {
+		if err := copyGokiVolume(v, gokiSnapshotVolume(name, v), gokiSnapshotLabel+"="+name); err != nil {
+			startGokiNodes(nodes)
+			return err
+		}
+	}
+
+	s := gokiSnapshot{Name: name, CreatedAt: time.Now(), Volumes: volumes, Metadata: m}
+	if err := writeGokiSnapshot(path, s); err != nil {
+		startGokiNodes(nodes)
+		return err
+	}
+
+	if err := startGokiNodes(nodes); err != nil {
+		return err
+	}
+	fmt.Println("*** Saving Snapshot " + name + " done ***")
+	return nil
+}
+
+func restoreGokiSnapshot(name string) error {
+	s, err := readGokiSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	containers, err := getGokiContainers()
+	if err != nil {
+		return err
+	}
+	snapshotNodes := gokiSnapshotNodeVolumes(s.Volumes)
+	if len(gokiNodeIdsOf(containers)) != 0 {
+		// Compare the volumes of all stores. Restoring a snapshot with another number of stores (--stores) breaks the nodes.
+		volumes, err := getGokiVolumes()
+		if err != nil {
+			return err
+		}
+		current, stores := gokiSnapshotStoreVolumes(volumes), gokiSnapshotStoreVolumes(s.Volumes)
+		if strings.Join(current, ",") != strings.Join(stores, ",") {
+			fmt.Fprintf(os.Stderr, "ERROR: The volumes of the snapshot %v do not match the volumes of the cluster.\n", name)
+			fmt.Fprintf(os.Stderr, " The snapshot has %v, but the cluster has %v.\n", strings.Join(stores, ", "), strings.Join(current, ", "))
+			fmt.Fprintln(os.Stderr, "HINT: Please delete the cluster by \"goki delete\" command, and restore the snapshot again.")
+			return errors.New("the volumes do not match")
+		}
+	}
+
+	fmt.Println("INFO: *** Start Restoring Snapshot " + name + " ***")
+	nodes, err := stopGokiNodes()
+	if err != nil {
+		return err
+	}
+
+	// If the cluster does not exist, remove the volumes that are not in the snapshot. So, "goki create" will re-use the snapshot as is.
+	if len(containers) == 0 {
+		volumes, err := getGokiVolumes()
+		if err != nil {
+			return err
+		}
+		for _, v := range volumes {
+			if !gokiContains(s.Volumes, v) {
+				if output, err := gokiCommand("docker", "volume", "rm", v).CombinedOutput(); err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: docker volume rm command failed.\n Error is: %v\n", string(output))
+					return err
+				}
+			}
+		}
+	}
+
+	for _, v := range s.Volumes {
+		if err := copyGokiVolume(gokiSnapshotVolume(name, v), v, gokiResourceLabel); err != nil {
+			// Do not leave the nodes stopped. The volumes may be restored partially, so restoring again is needed.
+			fmt.Fprintf(os.Stderr, "HINT: The volumes may be restored partially. Please restore the snapshot again by \"goki snapshot restore %v\" command.\n", name)
+			startGokiNodes(nodes)
+			return err
+		}
+	}
+
+	// The credentials in the snapshot are the ones in the restored data.
+	if s.Metadata != nil {
+		if err := saveGokiMetadata(s.Metadata); err != nil {
+			startGokiNodes(nodes)
+			return err
+		}
+	}
+
+	if err := startGokiNodes(nodes); err != nil {
+		return err
+	}
+	fmt.Println("*** Restoring Snapshot " + name + " done ***")
+
+	if len(containers) == 0 {
+		fmt.Println("INFO: The cluster does not exist. Only the volumes were restored.")
+		stores := ""
+		if s.Metadata != nil && s.Metadata.Stores > 1 {
+			stores = " --stores " + strconv.Itoa(s.Metadata.Stores)
+		}
+		if s.Metadata != nil && s.Metadata.Image != "" && s.Metadata.Image != crdbContainerImage+":"+s.Metadata.CrdbVersion {
+			fmt.Printf("HINT: Please start the cluster by \"goki create -n %d --image %v%v\" command.\n", len(snapshotNodes), s.Metadata.Image, stores)
+		} else if s.Metadata != nil {
+			fmt.Printf("HINT: Please start the cluster by \"goki create -n %d --crdb-version %v%v\" command.\n", len(snapshotNodes), s.Metadata.CrdbVersion, stores)
+		} else {
+			fmt.Printf("HINT: Please start the cluster by \"goki create -n %d\" command.\n", len(snapshotNodes))
+		}
+	}
+	return nil
+}
+
+func deleteGokiSnapshot(name string) error {
+	s, err := readGokiSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range s.Volumes {
+		c := gokiCommand("docker", "volume", "rm", gokiSnapshotVolume(name, v))
+		if output, err := c.CombinedOutput(); err != nil && !strings.Contains(string(output), "no such volume") {
+			fmt.Fprintf(os.Stderr, "ERROR: docker volume rm command failed.\n Error is: %v\n", string(output))
+			return err
+		}
+	}
+
+	path, err := gokiSnapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Removing snapshot metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
+
+func readGokiSnapshot(name string) (*gokiSnapshot, error) {
+	path, err := gokiSnapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "ERROR: The snapshot %v does not exist.\n", name)
+		fmt.Fprintln(os.Stderr, "HINT: You can see the snapshots by \"goki snapshot ls\" command.")
+		return nil, err
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Reading snapshot metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+
+	var s gokiSnapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Parsing snapshot metadata file %v failed.\n Error is: %v\n", path, err)
+		return nil, err
+	}
+	return &s, nil
+}
+
+func writeGokiSnapshot(path string, s gokiSnapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Encoding snapshot metadata failed.\n Error is: %v\n", err)
+		return err
+	}
+
+	// The snapshot metadata includes passwords. So, only the owner can read it.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Creating snapshot directory failed.\n Error is: %v\n", err)
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Writing snapshot metadata file %v failed.\n Error is: %v\n", path, err)
+		return err
+	}
+	return nil
+}
+
+func listGokiSnapshots() ([]gokiSnapshot, error) {
+	path, err := gokiSnapshotPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []gokiSnapshot{}, nil
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Reading snapshot directory failed.\n Error is: %v\n", err)
+		return nil, err
+	}
+
+	snapshots := []gokiSnapshot{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		s, err := readGokiSnapshot(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *s)
+	}
+	return snapshots, nil
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotLsCmd)
+	snapshotCmd.AddCommand(snapshotRmCmd)
+}
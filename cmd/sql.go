@@ -16,8 +16,8 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
 	"strconv"
 
 	"github.com/spf13/cobra"
@@ -26,7 +26,7 @@ import (
 // Flag value of sql command.
 var sqlCmdFlags struct {
 	gokiId   int  // Number of node (container).
-	nonRoot  bool // Version of CockroachDB that specified to tag of container image.
+	nonRoot  bool // Whether access as a default non-root user or not.
 	userName string
 	password string
 }
@@ -40,7 +40,7 @@ var sqlCmd = &cobra.Command{
     goki sql
 * You can specify the Node ID with -g (--goki) flag.
     goki sql -g 3
-* You can use default non-root user (User name : goki) with --non-root flag.
+* You can use default non-root user (User name : goki, or the one specified in "goki create") with --non-root flag.
     goki sql --non-root
 * You can use non-root user that you created by CREATE USER with -u (--user) and -p (--password) flag.
     goki sql -u foo -p foopass
@@ -51,12 +51,24 @@ var sqlCmd = &cobra.Command{
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
+		// Get the node and credentials of the cluster from the metadata.
+		m, err := loadGokiMetadata()
+		if err != nil {
+			return err
+		}
+		if err := checkGokiNodeId(m, sqlCmdFlags.gokiId); err != nil {
+			return err
+		}
+		if m != nil {
+			gokiCred = m.Credential
+		}
+
 		if !sqlCmdFlags.nonRoot && sqlCmdFlags.userName == "" { // Access to DB as a root user.
 			if err := accessGokiAsRoot(sqlCmdFlags.gokiId); err != nil {
 				return err
 			}
 		} else if sqlCmdFlags.nonRoot && sqlCmdFlags.userName == "" { // Access to DB as a default non-root user.
-			if err := accessGokiAsNonRoot(sqlCmdFlags.gokiId, gokiNonRootUserName, gokiNonRootUserPassword); err != nil {
+			if err := accessGokiAsNonRoot(sqlCmdFlags.gokiId, gokiCred.NonRootUserName, gokiCred.NonRootPassword); err != nil {
 				return err
 			}
 		} else if sqlCmdFlags.userName != "" { // Access to DB as a specified non-root user.
@@ -72,7 +84,7 @@ var sqlCmd = &cobra.Command{
 func accessGokiAsRoot(id int) error {
 	// Access to DB as a root user. Since I want to test certificate authentication method,
 	// I don't use password authentication for root.
-	c := exec.Command("docker", "exec", "-it", gokiResourceName+"-client",
+	c := gokiCommand("docker", "exec", "-it", gokiResourceName+"-client",
 		"./cockroach", "sql",
 		"--certs-dir=/cockroach/certs/",
 		"--host="+gokiResourceName+"-"+strconv.Itoa(id)+":26257",
@@ -92,10 +104,17 @@ func accessGokiAsRoot(id int) error {
 
 func accessGokiAsNonRoot(id int, user string, password string) error {
 	// Access to DB as a non-root user.
-	c := exec.Command("docker", "exec", "-it", gokiResourceName+"-client",
+	connStr := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(user, password),
+		Host:     gokiResourceName + "-" + strconv.Itoa(id) + ":26257",
+		Path:     "/defaultdb",
+		RawQuery: "sslmode=require",
+	}
+	c := gokiCommand("docker", "exec", "-it", gokiResourceName+"-client",
 		"./cockroach", "sql",
 		"--url",
-		"postgresql://"+user+":"+password+"@"+gokiResourceName+"-"+strconv.Itoa(id)+":26257/defaultdb?sslmode=require",
+		connStr.String(),
 	)
 
 	c.Stdin = os.Stdin
@@ -15,94 +15,528 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// Flag value of status command.
+var statusCmdFlags struct {
+	output   string        // Output format (table, json, or yaml).
+	watch    bool          // Whether refresh the status repeatedly or not.
+	interval time.Duration // Interval of refreshing the status in watch mode.
+}
+
+// Status of the cluster that "goki status" shows.
+type gokiStatus struct {
+	CrdbVersion    string           `json:"crdb_version,omitempty"`    // Version of CockroachDB in the metadata.
+	ClusterVersion string           `json:"cluster_version,omitempty"` // Active cluster version (e.g. 23.2). In a mixed-version cluster, it is the older one until finalized.
+	Stores         int              `json:"stores,omitempty"`          // Number of stores per node.
+	MemStore       string           `json:"mem_store,omitempty"`       // Store spec of in-memory stores.
+	EncryptionKey  string           `json:"encryption_key,omitempty"`  // Active store key of encryption at rest.
+	Image          string           `json:"image,omitempty"`           // Container image of CockroachDB in the metadata.
+	Binary         string           `json:"binary,omitempty"`          // Path of the locally built cockroach binary in the metadata.
+	CreatedAt      *time.Time       `json:"created_at,omitempty"`      // Time when "goki create" created the cluster.
+	Nodes          []gokiNodeStatus `json:"nodes"`                     // Status of each node.
+}
+
+// Status of each node. It combines the container state and "cockroach node status".
+// The fields from "cockroach node status" are zero value, if Goki could not get them.
+type gokiNodeStatus struct {
+	Name                  string     `json:"name"`                   // Container name.
+	Container             string     `json:"container"`              // State of container (e.g. running, exited, or missing).
+	Ports                 string     `json:"ports"`                  // Published ports of container.
+	NodeId                int        `json:"node_id"`                // CockroachDB's internal node ID.
+	Locality              string     `json:"locality"`               // Locality of node.
+	IsLive                bool       `json:"is_live"`                // Liveness of node.
+	IsAvailable           bool       `json:"is_available"`           // Whether the node is available or not.
+	IsDraining            bool       `json:"is_draining"`            // Whether the node is draining or not.
+	IsDecommissioning     bool       `json:"is_decommissioning"`     // Whether the node is decommissioning or not.
+	Membership            string     `json:"membership"`             // Membership of node (e.g. active, decommissioning).
+	Ranges                int        `json:"ranges"`                 // Number of ranges that the node has.
+	RangesUnderReplicated int        `json:"ranges_underreplicated"` // Number of under-replicated ranges.
+	RangesUnavailable     int        `json:"ranges_unavailable"`     // Number of unavailable ranges.
+	Replicas              int        `json:"replicas"`               // Number of replicas that the node has.
+	Leaseholders          int        `json:"leaseholders"`           // Number of leaseholders that the node has.
+	Version               string     `json:"version"`                // Build version of CockroachDB.
+	CrdbVersion           string     `json:"crdb_version,omitempty"` // Version of CockroachDB of the node in the metadata.
+	Cpus                  float64    `json:"cpus"`                   // Number of CPUs of the container. 0 means unlimited.
+	Memory                int64      `json:"memory"`                 // Memory limit of the container in bytes. 0 means unlimited.
+	StartedAt             *time.Time `json:"started_at,omitempty"`   // Time when the node started.
+	Uptime                string     `json:"uptime"`                 // Uptime of node, if the node is running.
+}
+
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
-	Short: "Show container status",
-	Long: `Show container status.
+	Short: "Show cluster and container status",
+	Long: `The "goki status" command shows the status of each node.
+It combines the container state and the CockroachDB node status (node ID, locality, liveness, ranges, etc...).
+* By default, it shows the status as a table.
+    goki status
+* You can specify the output format table, json, or yaml with -o (--output) flag.
+    goki status -o json
+* You can refresh the status repeatedly with -w (--watch) flag, and specify the interval with --interval flag.
+  It also shows the transitions of nodes (died, came back, became suspect, etc...). To exit, use Ctrl-C.
+    goki status -w --interval 5s
 You can kill/start containers of Goki using "goki jet (or kill)" and "goki revive (or start)" command.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		if n, err := getNumberOfContainers(); err != nil {
+		if err := checkOutputFormat(statusCmdFlags.output); err != nil {
+			return err
+		}
+
+		if statusCmdFlags.watch {
+			if statusCmdFlags.output != gokiOutputTable || statusCmdFlags.interval <= 0 {
+				fmt.Fprintln(os.Stderr, "ERROR: Invalid argument. The --watch flag needs table output and positive --interval.")
+				return errors.New("invalid argument. The --watch flag needs table output and positive --interval")
+			}
+			return watchGokiStatus(statusCmdFlags.interval)
+		}
+
+		s, err := getGokiStatus()
+		if err != nil {
 			return err
-		} else if n == 0 {
+		} else if s == nil {
 			fmt.Fprintln(os.Stderr, "There is no containers of Goki.")
 			return nil
-		} else if n > 0 {
-			if err = showContainerStatus(n); err != nil {
-				return err
-			}
+		}
+
+		if err := writeGokiStatus(os.Stdout, statusCmdFlags.output, s); err != nil {
+			return err
 		}
 
 		return nil
 	},
 }
 
-func getNumberOfContainers() (int, error) {
-	// List of all containers that includes stopped (killed) containers.
-	var gokiList []string = []string{}
+// getGokiStatus() returns nil (without error), if there is no containers of Goki.
+func getGokiStatus() (*gokiStatus, error) {
+	containers, err := getGokiContainers()
+	if err != nil {
+		return nil, err
+	} else if len(containers) == 0 {
+		return nil, nil
+	}
+
+	// Get the nodes from the metadata.
+	// If there is no metadata (e.g. the cluster was created by old Goki),
+	// assume that all containers named "goki-<number>" are nodes.
+	m, err := loadGokiMetadata()
+	if err != nil {
+		return nil, err
+	}
 
-	// Get list of stopped containers to remove them.
-	c := exec.Command("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}")
+	s := &gokiStatus{Nodes: []gokiNodeStatus{}}
+	if m != nil {
+		s.CrdbVersion = m.CrdbVersion
+		s.Image = m.Image
+		s.Binary = m.Binary
+		s.Stores = m.Stores
+		s.MemStore = m.MemStore
+		if m.Encryption != nil {
+			s.EncryptionKey = m.Encryption.Key
+		}
+		createdAt := m.CreatedAt
+		s.CreatedAt = &createdAt
+		for _, node := range m.Nodes {
+			s.Nodes = append(s.Nodes, gokiNodeStatus{Name: node.Name, Locality: node.Locality, CrdbVersion: node.CrdbVersion})
+		}
+	} else {
+		for _, id := range gokiNodeIdsOf(containers) {
+			s.Nodes = append(s.Nodes, gokiNodeStatus{Name: gokiResourceName + "-" + strconv.Itoa(id)})
+		}
+	}
 
-	if output, err := c.CombinedOutput(); err != nil {
+	// Set the container state.
+	host := ""
+	names := []string{}
+	for i := range s.Nodes {
+		c, ok := containers[s.Nodes[i].Name]
+		if !ok {
+			s.Nodes[i].Container = "missing"
+			continue
+		}
+		s.Nodes[i].Container = c.state
+		s.Nodes[i].Ports = c.ports
+		names = append(names, s.Nodes[i].Name)
+		if host == "" && c.state == "running" {
+			host = s.Nodes[i].Name
+		}
+	}
+
+	// Set the limits of CPU and memory of each container.
+	limits, err := getGokiResourceLimits(names)
+	if err != nil {
+		return nil, err
+	}
+	for i := range s.Nodes {
+		if l, ok := limits[s.Nodes[i].Name]; ok {
+			s.Nodes[i].Cpus = l.cpus
+			s.Nodes[i].Memory = l.memory
+		}
+	}
+
+	// Set the CockroachDB node status via a running node.
+	// If Goki cannot get it (e.g. all nodes are dead), show the container state only.
+	if c, ok := containers[gokiResourceName+"-client"]; !ok || c.state != "running" || host == "" {
+		return s, nil
+	}
+	crdbStatus, err := getCrdbNodeStatus(host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "INFO: Getting CockroachDB node status failed. Show the container state only.")
+		return s, nil
+	}
+	for i := range s.Nodes {
+		if row, ok := crdbStatus[s.Nodes[i].Name]; ok {
+			setCrdbNodeStatus(&s.Nodes[i], row)
+		}
+	}
+
+	if output, err := execGokiSql(host, "csv", "SHOW CLUSTER SETTING version"); err == nil {
+		if lines := strings.Fields(string(output)); len(lines) == 2 {
+			s.ClusterVersion = lines[1]
+		}
+	}
+
+	return s, nil
+}
+
+// crdbVersionsOf() returns the distinct versions of CockroachDB of the nodes in the metadata.
+// If the nodes run different versions (mixed-version cluster), it returns multiple versions.
+func crdbVersionsOf(s *gokiStatus) []string {
+	versions := []string{}
+	for _, n := range s.Nodes {
+		v := n.CrdbVersion
+		if v == "" {
+			v = s.CrdbVersion
+		}
+		found := false
+		for _, version := range versions {
+			found = found || version == v
+		}
+		if !found {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// State of each container that "docker ps" shows.
+type gokiContainer struct {
+	state string // State of container (e.g. running, exited, paused).
+	ports string // Published ports of container.
+}
+
+// getGokiContainers() returns all containers related to Goki (key is container name).
+func getGokiContainers() (map[string]gokiContainer, error) {
+	containers := map[string]gokiContainer{}
+
+	c := gokiCommand("docker", "ps", "-af", "label="+gokiResourceLabel, "--format", "{{.Names}}\t{{.State}}\t{{.Ports}}")
+
+	output, err := c.CombinedOutput()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "docker ps command failed: %v\n", string(output))
-		return -1, err
-	} else if string(output) != "" {
-		gokiList = strings.FieldsFunc(string(output), func(r rune) bool { return r == '\n' })
+		return nil, err
 	}
 
-	return len(gokiList), nil
+	for _, line := range strings.FieldsFunc(string(output), func(r rune) bool { return r == '\n' }) {
+		f := strings.SplitN(line, "\t", 3)
+		for len(f) < 3 {
+			f = append(f, "")
+		}
+		containers[f[0]] = gokiContainer{state: f[1], ports: f[2]}
+	}
+
+	return containers, nil
 }
 
-func showContainerStatus(n int) error {
-	// List of running containers.
-	var liveGokiList []string = []string{}
-	// List of stopped (killed) containers.
-	var deadGokiList []string = []string{}
+// gokiNodeIdsOf() returns sorted IDs of containers named "goki-<number>".
+func gokiNodeIdsOf(containers map[string]gokiContainer) []int {
+	ids := []int{}
+	for name := range containers {
+		if id, err := strconv.Atoi(strings.TrimPrefix(name, gokiResourceName+"-")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
 
-	// getNumberOfContainers() returns the number of all containers include "goki-client".
-	// We need to check from "goki-1" to "goki-n" other tan "goki-client".
-	for i := 1; i <= n-1; i++ {
-		if dead, err := gokiIsDead(i); err != nil {
+// getCrdbNodeStatus() returns the rows of "cockroach node status --all" (key is container name).
+// Each row is a map from the column name to the value.
+func getCrdbNodeStatus(host string) (map[string]map[string]string, error) {
+	ctx, cancel := context.WithTimeout(gokiContext(), gokiStatusTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, "docker", "exec", gokiResourceName+"-client",
+		"./cockroach", "node", "status", "--all",
+		"--format=csv",
+		"--certs-dir=/cockroach/certs/",
+		"--host="+host+":26257",
+	)
+
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	output, err := c.Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: cockroach node status command failed.\n Error is: %v\n", stderr.String())
+		return nil, err
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(output)).ReadAll()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Parsing the output of cockroach node status command failed.\n Error is: %v\n", err)
+		return nil, err
+	}
+
+	rows := map[string]map[string]string{}
+	if len(records) == 0 {
+		return rows, nil
+	}
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, column := range records[0] {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		// The address is "<container name>:26257".
+		name := strings.Split(row["address"], ":")[0]
+		rows[name] = row
+	}
+	return rows, nil
+}
+
+func setCrdbNodeStatus(n *gokiNodeStatus, row map[string]string) {
+	n.NodeId, _ = strconv.Atoi(row["id"])
+	if row["locality"] != "" {
+		n.Locality = row["locality"]
+	}
+	n.IsLive = row["is_live"] == "true"
+	n.IsAvailable = row["is_available"] == "true"
+	n.IsDraining = row["is_draining"] == "true"
+	n.IsDecommissioning = row["is_decommissioning"] == "true"
+	n.Membership = row["membership"]
+	n.Ranges, _ = strconv.Atoi(row["ranges"])
+	n.RangesUnderReplicated, _ = strconv.Atoi(row["ranges_underreplicated"])
+	n.RangesUnavailable, _ = strconv.Atoi(row["ranges_unavailable"])
+	n.Replicas, _ = strconv.Atoi(row["gossiped_replicas"])
+	n.Leaseholders, _ = strconv.Atoi(row["replicas_leaseholders"])
+	n.Version = row["build"]
+
+	if t, err := parseCrdbTimestamp(row["started_at"]); err == nil {
+		n.StartedAt = &t
+		if n.Container == "running" && n.IsLive {
+			n.Uptime = time.Since(t).Round(time.Second).String()
+		}
+	}
+}
+
+// parseCrdbTimestamp() parses the timestamp that CockroachDB's CLI outputs (e.g. "2022-01-01 00:00:00.123456").
+// The timestamp without time zone is UTC.
+func parseCrdbTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999", s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02 15:04:05.999999999-07:00", s)
+	}
+	return t, err
+}
+
+func writeGokiStatus(w io.Writer, format string, s *gokiStatus) error {
+	if format != gokiOutputTable {
+		return writeStructured(w, format, s)
+	}
+
+	if s.CreatedAt != nil {
+		fmt.Fprintln(w, "Cluster:")
+		if versions := crdbVersionsOf(s); len(versions) > 1 {
+			fmt.Fprintln(w, "  CockroachDB version: "+strings.Join(versions, ", ")+" (mixed)")
+		} else {
+			fmt.Fprintln(w, "  CockroachDB version: "+s.CrdbVersion)
+		}
+		if s.EncryptionKey != "" {
+			fmt.Fprintln(w, "  Encryption at rest: "+s.EncryptionKey+" (see \"goki encryption status\")")
+		}
+		if s.ClusterVersion != "" {
+			fmt.Fprintln(w, "  Cluster version: "+s.ClusterVersion)
+		}
+		if s.Image != "" && s.Image != crdbContainerImage+":"+s.CrdbVersion {
+			fmt.Fprintln(w, "  Image: "+s.Image)
+		}
+		if s.Binary != "" {
+			fmt.Fprintln(w, "  Binary: "+s.Binary)
+		}
+		fmt.Fprintln(w, "  Number of nodes: "+strconv.Itoa(len(s.Nodes)))
+		if s.MemStore != "" {
+			fmt.Fprintf(w, "  Stores per node: %d (in-memory, %v)\n", s.Stores, s.MemStore)
+		} else if s.Stores > 1 {
+			fmt.Fprintf(w, "  Stores per node: %d\n", s.Stores)
+		}
+		fmt.Fprintln(w, "  Created at: "+s.CreatedAt.Local().Format(time.RFC3339))
+		fmt.Fprintln(w, "")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCONTAINER\tNODE_ID\tLOCALITY\tLIVE\tDRAINING\tDECOMMISSIONING\tRANGES\tUNDER_REPLICATED\tUNAVAILABLE\tREPLICAS\tLEASEHOLDERS\tVERSION\tUPTIME\tCPUS\tMEMORY\tPORTS")
+	for _, n := range s.Nodes {
+		if n.NodeId == 0 {
+			// Goki could not get the CockroachDB node status. Show the version in the metadata instead.
+			fmt.Fprintf(tw, "%v\t%v\t-\t%v\t-\t-\t-\t-\t-\t-\t-\t-\t%v\t-\t%v\t%v\t%v\n",
+				n.Name, n.Container, orDash(n.Locality), orDash(n.CrdbVersion), formatGokiCpus(n.Cpus), formatGokiMemoryLimit(n.Memory), orDash(n.Ports))
+			continue
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			n.Name, n.Container, n.NodeId, orDash(n.Locality), n.IsLive, n.IsDraining, n.IsDecommissioning,
+			n.Ranges, n.RangesUnderReplicated, n.RangesUnavailable, n.Replicas, n.Leaseholders,
+			orDash(n.Version), orDash(n.Uptime), formatGokiCpus(n.Cpus), formatGokiMemoryLimit(n.Memory), orDash(n.Ports))
+	}
+	return tw.Flush()
+}
+
+// watchGokiStatus() refreshes the status in place until Ctrl-C (SIGINT) or SIGTERM.
+// If getting the status fails (e.g. while a node is restarting), it shows the error with the previous status, and keeps refreshing.
+func watchGokiStatus(interval time.Duration) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev *gokiStatus
+	var events []string
+	for {
+		s, err := getGokiStatus()
+		select {
+		case <-sig:
+			// The docker command may fail because it also receives the signal.
+			fmt.Println()
+			return nil
+		default:
+		}
+		if err != nil && gokiRootCtx.Err() != nil {
+			// Timed out (--timeout).
 			return err
-		} else if dead {
-			deadGokiList = append(deadGokiList, gokiResourceName+"-"+strconv.Itoa(i))
-		} else if !dead {
-			liveGokiList = append(liveGokiList, gokiResourceName+"-"+strconv.Itoa(i))
+		}
+
+		now := time.Now()
+		if err == nil {
+			events = append(events, gokiStatusEvents(prev, s, now)...)
+			if len(events) > gokiWatchMaxEvents {
+				events = events[len(events)-gokiWatchMaxEvents:]
+			}
+			prev = s
+		} else {
+			s = prev
+		}
+
+		// Clear the screen, and write the status from the top left.
+		var b bytes.Buffer
+		b.WriteString("\033[H\033[2J")
+		fmt.Fprintf(&b, "Every %v: goki status (Ctrl-C to exit)    %v\n\n", interval, now.Format("15:04:05"))
+		if err != nil {
+			fmt.Fprintln(&b, colorize(gokiColorRed, "ERROR: Getting the status failed. Retrying, and showing the previous status.\n Error is: "+err.Error()))
+			fmt.Fprintln(&b)
+		}
+		if s == nil && err == nil {
+			fmt.Fprintln(&b, "There is no containers of Goki.")
+		} else if s != nil {
+			if err := writeGokiStatus(&b, gokiOutputTable, s); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(&b, "\nEvents:")
+		if len(events) == 0 {
+			fmt.Fprintln(&b, "  Nothing")
+		}
+		for _, e := range events {
+			fmt.Fprintln(&b, "  "+e)
+		}
+		os.Stdout.Write(b.Bytes())
+
+		select {
+		case <-sig:
+			fmt.Println()
+			return nil
+		case <-ticker.C:
 		}
 	}
+}
 
-	// Show alive containers.
-	fmt.Fprintln(os.Stdout, "Alive containers:")
-	if len(liveGokiList) == 0 {
-		fmt.Fprintln(os.Stdout, "  Nothing")
+// gokiStatusEvents() returns the transitions between the previous status and the current status.
+func gokiStatusEvents(prev *gokiStatus, cur *gokiStatus, t time.Time) []string {
+	if prev == nil || cur == nil {
+		return nil
 	}
-	for i := 0; i < len(liveGokiList); i++ {
-		fmt.Fprintln(os.Stdout, "  "+liveGokiList[i])
+
+	ts := t.Format("15:04:05")
+	events := []string{}
+	prevNodes := map[string]gokiNodeStatus{}
+	for _, n := range prev.Nodes {
+		prevNodes[n.Name] = n
 	}
 
-	// Show dead containers.
-	fmt.Fprintln(os.Stdout, "Dead containers:")
-	if len(deadGokiList) == 0 {
-		fmt.Fprintln(os.Stdout, "  Nothing")
+	prevUnderReplicated, curUnderReplicated := 0, 0
+	prevKnown, curKnown := false, false
+	for _, n := range cur.Nodes {
+		p, ok := prevNodes[n.Name]
+		if !ok {
+			continue
+		}
+		prevUnderReplicated += p.RangesUnderReplicated
+		curUnderReplicated += n.RangesUnderReplicated
+		prevKnown = prevKnown || p.NodeId != 0
+		curKnown = curKnown || n.NodeId != 0
+
+		switch {
+		case p.Container == "running" && n.Container != "running":
+			events = append(events, colorize(gokiColorRed, ts+" "+n.Name+" died (container is "+n.Container+")"))
+		case p.Container != "running" && n.Container == "running":
+			events = append(events, colorize(gokiColorGreen, ts+" "+n.Name+" came back"))
+		case p.NodeId != 0 && n.NodeId != 0 && p.IsLive && !n.IsLive:
+			events = append(events, colorize(gokiColorYellow, ts+" "+n.Name+" became suspect (not live)"))
+		case p.NodeId != 0 && n.NodeId != 0 && !p.IsLive && n.IsLive:
+			events = append(events, colorize(gokiColorGreen, ts+" "+n.Name+" became live"))
+		}
 	}
-	for i := 0; i < len(deadGokiList); i++ {
-		fmt.Fprintln(os.Stdout, "  "+deadGokiList[i])
+
+	if prevKnown && curKnown {
+		if prevUnderReplicated == 0 && curUnderReplicated > 0 {
+			events = append(events, colorize(gokiColorYellow, ts+" "+strconv.Itoa(curUnderReplicated)+" ranges became under-replicated"))
+		} else if prevUnderReplicated > 0 && curUnderReplicated == 0 {
+			events = append(events, colorize(gokiColorGreen, ts+" all ranges are fully replicated"))
+		}
 	}
 
-	return nil
+	return events
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	// Flags of goki status.
+	statusCmd.Flags().StringVarP(&statusCmdFlags.output, "output", "o", gokiOutputTable, "Output format (table, json, or yaml).")
+	statusCmd.Flags().BoolVarP(&statusCmdFlags.watch, "watch", "w", false, "Refresh the status repeatedly until Ctrl-C.")
+	statusCmd.Flags().DurationVar(&statusCmdFlags.interval, "interval", 2*time.Second, "Interval of refreshing the status in watch mode.")
 }
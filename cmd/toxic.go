@@ -0,0 +1,264 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Types of toxics.
+	gokiToxicLatency   string = "latency"   // Delay the data.
+	gokiToxicBandwidth string = "bandwidth" // Limit the bandwidth.
+	gokiToxicReset     string = "reset"     // Reset the connections (TCP RST).
+	gokiToxicTimeout   string = "timeout"   // Drop the data (half-open connection), and close the connections after the timeout.
+	// Directions of toxics.
+	gokiToxicUpstream   string = "upstream"   // From client to node.
+	gokiToxicDownstream string = "downstream" // From node to client.
+	gokiToxicBoth       string = "both"       // Both directions.
+)
+
+// Toxic that the proxy applies to the connections.
+type gokiToxic struct {
+	Name      string        `json:"name"`              // Name of the toxic. It is unique in the proxy.
+	Type      string        `json:"type"`              // One of latency, bandwidth, reset, and timeout.
+	Direction string        `json:"direction"`         // One of upstream, downstream, and both.
+	Latency   time.Duration `json:"latency,omitempty"` // Delay of latency toxic.
+	Jitter    time.Duration `json:"jitter,omitempty"`  // Random variation of latency toxic.
+	Rate      int64         `json:"rate,omitempty"`    // Bytes per second of bandwidth toxic.
+	Timeout   time.Duration `json:"timeout,omitempty"` // Time until reset and timeout toxic close the connections. 0 means immediately for reset, and never for timeout.
+	id        int           // Internal ID to distinguish toxics that have the same name.
+}
+
+// newGokiToxic() creates the toxic from the type and the value in the command line (e.g. "latency 200ms", "bandwidth 1mb").
+func newGokiToxic(typ string, value string, name string, direction string, jitter time.Duration) (gokiToxic, error) {
+	t := gokiToxic{Name: name, Type: typ, Direction: direction}
+	if t.Name == "" {
+		t.Name = typ
+	}
+
+	var err error
+	switch typ {
+	case gokiToxicLatency:
+		if value == "" {
+			return t, errors.New("latency toxic needs the delay (e.g. 200ms)")
+		}
+		t.Latency, err = time.ParseDuration(value)
+		t.Jitter = jitter
+	case gokiToxicBandwidth:
+		if value == "" {
+			return t, errors.New("bandwidth toxic needs the rate per second (e.g. 100kb)")
+		}
+		t.Rate, err = parseGokiSize(strings.TrimSuffix(value, "/s"))
+	case gokiToxicReset, gokiToxicTimeout:
+		if value != "" {
+			t.Timeout, err = time.ParseDuration(value)
+		}
+	}
+	if err != nil {
+		return t, err
+	}
+
+	return t, t.validate()
+}
+
+func (t gokiToxic) validate() error {
+	if t.Name == "" || strings.Contains(t.Name, "/") {
+		return errors.New("the name of toxic must not be empty or contain \"/\"")
+	}
+	switch t.Type {
+	case gokiToxicLatency, gokiToxicBandwidth, gokiToxicReset, gokiToxicTimeout:
+	default:
+		return errors.New("unknown toxic type " + t.Type + ". Please specify one of latency, bandwidth, reset, and timeout")
+	}
+	if t.Type == gokiToxicBandwidth && t.Rate <= 0 {
+		return errors.New("the rate of bandwidth toxic must be greater than 0")
+	}
+	if t.Direction != gokiToxicUpstream && t.Direction != gokiToxicDownstream && t.Direction != gokiToxicBoth {
+		return errors.New("unknown direction " + t.Direction + ". Please specify one of upstream, downstream, and both")
+	}
+	if t.Latency < 0 || t.Jitter < 0 || t.Rate < 0 || t.Timeout < 0 {
+		return errors.New("the values of toxic must not be negative")
+	}
+	return nil
+}
+
+// attributes() returns the values of the toxic for "goki proxy toxic ls".
+func (t gokiToxic) attributes() string {
+	switch t.Type {
+	case gokiToxicLatency:
+		if t.Jitter != 0 {
+			return "latency=" + t.Latency.String() + " jitter=" + t.Jitter.String()
+		}
+		return "latency=" + t.Latency.String()
+	case gokiToxicBandwidth:
+		return "rate=" + formatGokiRate(t.Rate)
+	default:
+		return "timeout=" + t.Timeout.String()
+	}
+}
+
+func (t gokiToxic) appliesTo(direction string) bool {
+	return t.Direction == gokiToxicBoth || t.Direction == direction
+}
+
+// A connection between a client and the upstream via the proxy.
+type gokiProxyConn struct {
+	client   net.Conn
+	upstream net.Conn
+	once     sync.Once
+}
+
+// close() closes both sides of the connection. If reset is true, it sends TCP RST instead of FIN.
+func (c *gokiProxyConn) close(reset bool) {
+	c.once.Do(func() {
+		for _, conn := range []net.Conn{c.client, c.upstream} {
+			if tc, ok := conn.(*net.TCPConn); ok && reset {
+				tc.SetLinger(0)
+			}
+			conn.Close()
+		}
+	})
+}
+
+// Data that the proxy read from one side of the connection.
+type gokiProxyChunk struct {
+	data    []byte
+	release time.Time // Time when the proxy writes the data to the other side. It is the arrival time plus the latency.
+}
+
+// pipe() copies the data from src to dst with the toxics of the direction, until either side is closed.
+// It stamps each chunk with the release time when it arrives, and the writer releases the chunks in order.
+// So, the latency toxic delays each chunk by the latency from its arrival, and does not add up the latency of the preceding chunks.
+func (p *gokiProxy) pipe(c *gokiProxyConn, dst net.Conn, src net.Conn, direction string) {
+	defer c.close(false)
+
+	chunks := make(chan gokiProxyChunk, gokiProxyQueueSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.release(c, dst, chunks, direction)
+	}()
+	// Write the data that already arrived before closing the connection.
+	defer func() {
+		close(chunks)
+		<-done
+	}()
+
+	buf := make([]byte, gokiProxyBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if delay, ok := p.delayOf(direction); ok {
+				chunk := gokiProxyChunk{data: append([]byte{}, buf[:n]...), release: time.Now().Add(delay)}
+				select {
+				case chunks <- chunk:
+				case <-done:
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// release() writes each chunk to dst at its release time. If writing fails, it closes the connection, so that pipe() stops reading.
+func (p *gokiProxy) release(c *gokiProxyConn, dst net.Conn, chunks <-chan gokiProxyChunk, direction string) {
+	for chunk := range chunks {
+		// The jitter can make the release time earlier than the previous chunk. Keep the order of the data as TCP does.
+		if wait := time.Until(chunk.release); wait > 0 {
+			time.Sleep(wait)
+		}
+		if err := p.forward(dst, chunk.data, direction); err != nil {
+			c.close(false)
+			return
+		}
+	}
+}
+
+// delayOf() returns the delay of the data that arrives now by the current latency toxics.
+// It returns false if the timeout toxic drops the data.
+func (p *gokiProxy) delayOf(direction string) (time.Duration, bool) {
+	delay := time.Duration(0)
+	for _, t := range p.toxicsOf(direction) {
+		switch t.Type {
+		case gokiToxicTimeout:
+			// The data does not reach the peer, but the connection stays open.
+			return 0, false
+		case gokiToxicLatency:
+			d := t.Latency
+			if t.Jitter > 0 {
+				d += time.Duration(rand.Int63n(int64(2*t.Jitter))) - t.Jitter
+			}
+			if d > 0 {
+				delay += d
+			}
+		}
+	}
+	return delay, true
+}
+
+// forward() writes the data to dst at the rate of the current bandwidth toxics.
+func (p *gokiProxy) forward(dst net.Conn, b []byte, direction string) error {
+	rate := int64(0)
+	for _, t := range p.toxicsOf(direction) {
+		if t.Type == gokiToxicBandwidth && (rate == 0 || t.Rate < rate) {
+			rate = t.Rate
+		}
+	}
+
+	if rate == 0 {
+		_, err := dst.Write(b)
+		return err
+	}
+
+	// Write the data in small chunks, so that the data flows smoothly at the rate.
+	chunk := int(rate / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	for len(b) > 0 {
+		n := chunk
+		if len(b) < n {
+			n = len(b)
+		}
+		if _, err := dst.Write(b[:n]); err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(int64(n) * int64(time.Second) / rate))
+		b = b[n:]
+	}
+	return nil
+}
+
+// formatGokiRate() returns the human readable bytes per second.
+func formatGokiRate(rate int64) string {
+	switch {
+	case rate >= 1000*1000 && rate%(1000*1000) == 0:
+		return strconv.FormatInt(rate/(1000*1000), 10) + "MB/s"
+	case rate >= 1000 && rate%1000 == 0:
+		return strconv.FormatInt(rate/1000, 10) + "KB/s"
+	default:
+		return strconv.FormatInt(rate, 10) + "B/s"
+	}
+}
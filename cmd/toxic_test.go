@@ -0,0 +1,62 @@
+// Copyright 2022 kota2and3kan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGokiProxyLatency(t *testing.T) {
+	latency := 200 * time.Millisecond
+	p := &gokiProxy{toxics: []gokiToxic{{Name: "latency", Type: gokiToxicLatency, Direction: gokiToxicBoth, Latency: latency}}}
+
+	client, src := net.Pipe()
+	dst, server := net.Pipe()
+	c := &gokiProxyConn{client: src, upstream: dst}
+	go p.pipe(c, dst, src, gokiToxicUpstream)
+	defer c.close(false)
+	defer client.Close()
+
+	// Send the chunks at intervals shorter than the latency.
+	chunks := 5
+	interval := 20 * time.Millisecond
+	go func() {
+		for i := 0; i < chunks; i++ {
+			client.Write([]byte{byte(i)})
+			time.Sleep(interval)
+		}
+	}()
+
+	start := time.Now()
+	buf := make([]byte, 1)
+	for i := 0; i < chunks; i++ {
+		if _, err := server.Read(buf); err != nil {
+			t.Fatalf("reading chunk %d failed: %v", i, err)
+		}
+		if buf[0] != byte(i) {
+			t.Fatalf("chunk %d = %d, want %d", i, buf[0], i)
+		}
+		if elapsed := time.Since(start); elapsed < latency {
+			t.Errorf("chunk %d arrived after %v, want at least %v", i, elapsed, latency)
+		}
+	}
+
+	// Each chunk is delayed from its arrival. So, the latency of the preceding chunks does not add up.
+	if elapsed, limit := time.Since(start), latency+time.Duration(chunks)*interval+latency; elapsed > limit {
+		t.Errorf("all chunks arrived after %v, want at most %v", elapsed, limit)
+	}
+}